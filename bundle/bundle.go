@@ -0,0 +1,374 @@
+package bundle
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"extensionctl/chart"
+	"extensionctl/image"
+	"extensionctl/util"
+
+	"github.com/fatih/color"
+)
+
+const (
+	ManifestFile  = "manifest.json"
+	ChecksumsFile = "SHA256SUMS"
+	SchemaVersion = 1
+)
+
+// Manifest describes the contents of a bundle, it is the first file in the bundle
+type Manifest struct {
+	SchemaVersion       int    `json:"schema_version"`
+	Name                string `json:"name"`
+	ExtensionVersion    string `json:"extension_version"`
+	ChartVersion        string `json:"chart_version"`
+	ImageTag            string `json:"image_tag"`
+	KaapanaBuildVersion string `json:"kaapana_build_version"`
+	Registry            string `json:"registry"`
+	// Images are the tags of the images in the image archives
+	Images []string `json:"images"`
+	// Files are the charts, image archives and signatures in the bundle, paths are relative to the bundle root
+	Files []util.Artifact `json:"files"`
+}
+
+// Collect returns the artifacts of the current build that go into a bundle: the packaged charts
+// and the saved images, together with their signature files if the build was signed
+func Collect(config *util.ExtensionConfig) ([]util.Artifact, error) {
+	artifacts := []util.Artifact{}
+	add := func(kind string, filePath string, required bool) error {
+		if _, err := os.Stat(filePath); err != nil {
+			if required {
+				return errors.New(kind + " " + filePath + " does not exist, build the extension first")
+			}
+			return nil
+		}
+		artifact, err := util.NewArtifact(kind, filePath)
+		if err != nil {
+			return err
+		}
+		artifacts = append(artifacts, artifact)
+		return nil
+	}
+
+	for _, chartPath := range config.ChartPaths {
+		packaged, err := chart.ChartArtifactPath(config.ForChart(chartPath))
+		if err != nil {
+			return nil, err
+		}
+		if err := add("chart", packaged, true); err != nil {
+			return nil, err
+		}
+		if err := add("provenance", packaged+".prov", false); err != nil {
+			return nil, err
+		}
+	}
+
+	tarPath, err := util.ImagesArtifactPath(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := add("images", tarPath, true); err != nil {
+		return nil, err
+	}
+	digestsPath := image.DigestManifestPath(tarPath)
+	if err := add("digests", digestsPath, false); err != nil {
+		return nil, err
+	}
+	if err := add("signature", digestsPath+".asc", false); err != nil {
+		return nil, err
+	}
+	return artifacts, nil
+}
+
+func bundleDir(kind string) string {
+	if kind == "chart" || kind == "provenance" {
+		return "charts"
+	}
+	return "images"
+}
+
+// Create writes the bundle to bundlePath. The manifest is written first so that it can be
+// read without going through the (large) image archives.
+func Create(config *util.ExtensionConfig, artifacts []util.Artifact, bundlePath string) (*Manifest, error) {
+	manifest := &Manifest{
+		SchemaVersion:       SchemaVersion,
+		Name:                filepath.Base(config.DirPath),
+		ExtensionVersion:    config.Versions.Extension,
+		ChartVersion:        config.Versions.Chart,
+		ImageTag:            config.Versions.Image,
+		KaapanaBuildVersion: config.KaapanaBuildVersion,
+		Registry:            config.CustomRegistryUrl,
+		Images:              []string{},
+		Files:               []util.Artifact{},
+	}
+
+	sources := map[string]string{}
+	for _, artifact := range artifacts {
+		name := path.Join(bundleDir(artifact.Kind), filepath.Base(artifact.Path))
+		if _, ok := sources[name]; ok {
+			return nil, errors.New("bundle contains " + name + " twice")
+		}
+		sources[name] = artifact.Path
+		manifest.Files = append(manifest.Files, util.Artifact{Kind: artifact.Kind, Path: name, Size: artifact.Size, SHA256: artifact.SHA256})
+
+		if artifact.Kind == "images" {
+			images, err := image.ArchivedImages(artifact.Path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read images from %s: %w", artifact.Path, err)
+			}
+			manifest.Images = append(manifest.Images, images...)
+		}
+	}
+	sort.Strings(manifest.Images)
+
+	manifestContent, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	checksums := strings.Builder{}
+	for _, file := range manifest.Files {
+		fmt.Fprintf(&checksums, "%s  %s\n", file.SHA256, file.Path)
+	}
+
+	color.Blue("writing bundle %s", bundlePath)
+	out, err := os.Create(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+	tw := tar.NewWriter(out)
+
+	if err := writeBytes(tw, ManifestFile, manifestContent); err != nil {
+		return nil, err
+	}
+	if err := writeBytes(tw, ChecksumsFile, []byte(checksums.String())); err != nil {
+		return nil, err
+	}
+	for _, file := range manifest.Files {
+		if err := writeFile(tw, file, sources[file.Path]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func writeBytes(tw *tar.Writer, name string, content []byte) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := tw.Write(content)
+	return err
+}
+
+func writeFile(tw *tar.Writer, file util.Artifact, source string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	header := &tar.Header{Name: file.Path, Mode: 0644, Size: file.Size, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, in); err != nil {
+		return fmt.Errorf("failed to add %s to the bundle: %w", source, err)
+	}
+	return nil
+}
+
+// Inspect reads the manifest of a bundle
+func Inspect(bundlePath string) (*Manifest, error) {
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, errors.New(bundlePath + " does not contain a " + ManifestFile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle %s: %w", bundlePath, err)
+		}
+		if header.Name == ManifestFile {
+			return readManifest(tr, bundlePath)
+		}
+	}
+}
+
+func readManifest(r io.Reader, bundlePath string) (*Manifest, error) {
+	var manifest Manifest
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse %s of bundle %s: %w", ManifestFile, bundlePath, err)
+	}
+	if manifest.SchemaVersion != SchemaVersion {
+		return nil, fmt.Errorf("bundle %s has schema version %d, only %d is supported", bundlePath, manifest.SchemaVersion, SchemaVersion)
+	}
+	return &manifest, nil
+}
+
+// Verify reads the whole bundle and checks the size and checksum of every file against the
+// manifest. Missing files and files that are not listed in the manifest fail the verification.
+func Verify(bundlePath string) (*Manifest, error) {
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var manifest *Manifest
+	found := map[string]util.Artifact{}
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle %s: %w", bundlePath, err)
+		}
+		switch header.Name {
+		case ManifestFile:
+			manifest, err = readManifest(tr, bundlePath)
+			if err != nil {
+				return nil, err
+			}
+		case ChecksumsFile:
+		default:
+			h := sha256.New()
+			size, err := io.Copy(h, tr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s from bundle %s: %w", header.Name, bundlePath, err)
+			}
+			found[header.Name] = util.Artifact{Path: header.Name, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}
+		}
+	}
+	if manifest == nil {
+		return nil, errors.New(bundlePath + " does not contain a " + ManifestFile)
+	}
+
+	problems := []string{}
+	for _, expected := range manifest.Files {
+		if !isLocalPath(expected.Path) {
+			problems = append(problems, expected.Path+" is not a relative path inside the bundle")
+			delete(found, expected.Path)
+			continue
+		}
+		actual, ok := found[expected.Path]
+		if !ok {
+			problems = append(problems, expected.Path+" is missing")
+			continue
+		}
+		if actual.Size != expected.Size || actual.SHA256 != expected.SHA256 {
+			problems = append(problems, fmt.Sprintf("checksum of %s is %s, expected %s", expected.Path, actual.SHA256, expected.SHA256))
+		}
+		delete(found, expected.Path)
+	}
+	for name := range found {
+		problems = append(problems, name+" is not listed in "+ManifestFile)
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		for _, problem := range problems {
+			color.Red("- %s", problem)
+		}
+		return manifest, errors.New("verification of bundle " + bundlePath + " failed")
+	}
+	return manifest, nil
+}
+
+// isLocalPath checks that a path of the bundle stays inside the directory it is extracted to,
+// i.e. it is not absolute and has no '..' components
+func isLocalPath(name string) bool {
+	return filepath.IsLocal(filepath.FromSlash(name))
+}
+
+// Extract verifies the bundle and writes its files into dir, keeping the charts/ and images/ folders
+func Extract(bundlePath string, dir string) (*Manifest, error) {
+	manifest, err := Verify(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	listed := map[string]bool{}
+	for _, file := range manifest.Files {
+		listed[file.Path] = true
+	}
+
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle %s: %w", bundlePath, err)
+		}
+		if !listed[header.Name] {
+			continue
+		}
+		if !isLocalPath(header.Name) {
+			return nil, errors.New(header.Name + " in bundle " + bundlePath + " is not a relative path inside the bundle")
+		}
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+		out, err := os.Create(target)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return nil, err
+		}
+		if err := out.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+func (manifest *Manifest) Print() {
+	fmt.Printf("Name: %s\n", manifest.Name)
+	fmt.Printf("Extension version: %s\n", manifest.ExtensionVersion)
+	fmt.Printf("Chart version: %s\n", manifest.ChartVersion)
+	fmt.Printf("Image tag: %s\n", manifest.ImageTag)
+	fmt.Printf("Kaapana version: %s\n", manifest.KaapanaBuildVersion)
+	fmt.Printf("Registry: %s\n", manifest.Registry)
+	fmt.Printf("Images:\n")
+	for _, img := range manifest.Images {
+		fmt.Printf("- %s\n", img)
+	}
+	fmt.Printf("Files:\n")
+	for _, file := range manifest.Files {
+		fmt.Printf("- %-10s %s (%d bytes, sha256:%s)\n", file.Kind, file.Path, file.Size, file.SHA256)
+	}
+}
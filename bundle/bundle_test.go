@@ -0,0 +1,145 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"extensionctl/util"
+)
+
+func writeTar(t *testing.T, path string, files map[string][]byte) {
+	t.Helper()
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	tw := tar.NewWriter(out)
+	for name, content := range files {
+		if err := writeBytes(tw, name, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testArtifacts(t *testing.T, dir string) []util.Artifact {
+	t.Helper()
+	chartPath := filepath.Join(dir, "otsus-method-0.1.0.tgz")
+	if err := os.WriteFile(chartPath, []byte("chart"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	imagesPath := filepath.Join(dir, "images.tar")
+	writeTar(t, imagesPath, map[string][]byte{
+		"manifest.json": []byte(`[{"Config":"abc.json","RepoTags":["registry.example.com/kaapana/otsus-method:0.1.0"],"Layers":[]}]`),
+	})
+
+	artifacts := []util.Artifact{}
+	for kind, path := range map[string]string{"chart": chartPath, "images": imagesPath} {
+		artifact, err := util.NewArtifact(kind, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	return artifacts
+}
+
+func TestCreateInspectVerify(t *testing.T) {
+	tmp := t.TempDir()
+	config := &util.ExtensionConfig{
+		DirPath:             filepath.Join(tmp, "otsus-method"),
+		KaapanaBuildVersion: "0.2.0",
+		CustomRegistryUrl:   "registry.example.com/kaapana",
+		Versions:            util.BuildVersions{Extension: "0.1.0", Image: "0.1.0", Chart: "0.1.0"},
+	}
+	bundlePath := filepath.Join(tmp, "otsus-method-0.1.0.bundle.tar")
+
+	if _, err := Create(config, testArtifacts(t, tmp), bundlePath); err != nil {
+		t.Fatalf("failed to create bundle: %v", err)
+	}
+
+	manifest, err := Inspect(bundlePath)
+	if err != nil {
+		t.Fatalf("failed to inspect bundle: %v", err)
+	}
+	if manifest.Name != "otsus-method" || manifest.ExtensionVersion != "0.1.0" || manifest.KaapanaBuildVersion != "0.2.0" || manifest.Registry != "registry.example.com/kaapana" {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	if len(manifest.Images) != 1 || manifest.Images[0] != "registry.example.com/kaapana/otsus-method:0.1.0" {
+		t.Fatalf("unexpected images %v", manifest.Images)
+	}
+	if len(manifest.Files) != 2 {
+		t.Fatalf("expected 2 files, got %v", manifest.Files)
+	}
+
+	if _, err := Verify(bundlePath); err != nil {
+		t.Fatalf("failed to verify bundle: %v", err)
+	}
+
+	extractDir := t.TempDir()
+	if _, err := Extract(bundlePath, extractDir); err != nil {
+		t.Fatalf("failed to extract bundle: %v", err)
+	}
+	if chart, err := os.ReadFile(filepath.Join(extractDir, "charts", "otsus-method-0.1.0.tgz")); err != nil || string(chart) != "chart" {
+		t.Fatalf("expected the chart to be extracted, got %q %v", chart, err)
+	}
+
+	// replace the chart in the bundle while keeping the manifest
+	files := map[string][]byte{}
+	content, err := os.ReadFile(bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(bytes.NewReader(content))
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(tr); err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = buf.Bytes()
+	}
+	files["charts/otsus-method-0.1.0.tgz"] = []byte("tampered")
+	writeTar(t, bundlePath, files)
+
+	if _, err := Verify(bundlePath); err == nil {
+		t.Fatalf("expected verification of a modified bundle to fail")
+	}
+}
+
+func TestExtractPathTraversal(t *testing.T) {
+	tmp := t.TempDir()
+	content := []byte("outside")
+	sum := sha256.Sum256(content)
+	for _, name := range []string{"../outside.txt", "charts/../../outside.txt", "/tmp/outside.txt"} {
+		manifest, err := json.Marshal(Manifest{
+			SchemaVersion: SchemaVersion,
+			Files:         []util.Artifact{{Kind: "chart", Path: name, Size: int64(len(content)), SHA256: hex.EncodeToString(sum[:])}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundlePath := filepath.Join(tmp, "malicious.bundle.tar")
+		writeTar(t, bundlePath, map[string][]byte{ManifestFile: manifest, name: content})
+
+		extractDir := filepath.Join(tmp, "extract", "dir")
+		if _, err := Extract(bundlePath, extractDir); err == nil {
+			t.Errorf("expected bundle with %s to be rejected", name)
+		}
+		if _, err := os.Stat(filepath.Join(tmp, "extract", "outside.txt")); err == nil {
+			t.Fatalf("%s was extracted outside of the target directory", name)
+		}
+	}
+}
@@ -4,13 +4,14 @@ import (
 	"bufio"
 	"errors"
 	"extensionctl/util"
+	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
-	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chartutil"
 )
 
 func readLines(filepath string) ([]string, error) {
@@ -33,90 +34,47 @@ func readLines(filepath string) ([]string, error) {
 	return lines, nil
 }
 
-func yamlRead(yamlPath string) (map[interface{}]interface{}, error) {
-	color.Blue("reading from yaml file %s", yamlPath)
-
-	f, err := os.ReadFile(yamlPath)
-	if err != nil {
-		color.Red(err.Error())
-		return nil, err
-	}
-
-	data := make(map[interface{}]interface{})
-
-	err = yaml.Unmarshal(f, &data)
-
-	if err != nil {
-		color.Red(err.Error())
-		return nil, err
-	}
-
-	color.Blue("successfully read from %s", yamlPath)
-
-	return data, nil
-}
-
-func yamlWrite(yamlPath string, data map[interface{}]interface{}) error {
-	color.Blue("writing to yaml file %s", yamlPath)
-	f, err := yaml.Marshal(data)
-
-	if err != nil {
-		color.Red(err.Error())
-		return err
-	}
-
-	err = os.WriteFile(yamlPath, f, 0)
-
-	if err != nil {
-		color.Red(err.Error())
-		return err
-	}
-
-	color.Blue("successfully written to %s", yamlPath)
-
-	return nil
+func isChartFolder(path string) bool {
+	info, err := os.Stat(filepath.Join(path, "Chart.yaml"))
+	return err == nil && !info.IsDir()
 }
 
-func findYamlInChartPath(chartPath string, fileName string) (string, error) {
-	foundPath := ""
-
-	err := filepath.Walk(chartPath, func(filePath string, info os.FileInfo, err error) error {
-		// go through the chartPath to find yaml files
-		if err != nil {
-			color.Red(err.Error())
-			return err
-		}
-
-		if !info.IsDir() && info.Name() == fileName {
-			color.Blue("found yaml file '%s' under %s", fileName, filePath)
-			foundPath = filePath
+// FindChartPaths sets config.ChartPaths. Explicit chart_paths (or the older chart_path) are
+// validated, otherwise the charts under dir_path are discovered. If more than one chart is
+// found, chart_paths has to be set in the config to choose the charts that are packaged.
+func FindChartPaths(config *util.ExtensionConfig) (_ *util.ExtensionConfig, err error) {
+	defer wrapError("", &err)
+	if len(config.ChartPaths) == 0 && config.ChartPath != "" {
+		color.Yellow("chart_path is deprecated, moving %s to chart_paths", config.ChartPath)
+		config.ChartPaths = []string{config.ChartPath}
+	}
+	config.ChartPath = ""
+
+	if len(config.ChartPaths) > 0 {
+		for _, chartPath := range config.ChartPaths {
+			if !isChartFolder(chartPath) {
+				return nil, errors.New("chart path " + chartPath + " in chart_paths does not contain a Chart.yaml")
+			}
 		}
-
-		return nil
-	})
-
-	if err != nil {
-		return "", err
+		return config, nil
 	}
-	return foundPath, nil
-}
-
-func FindChartPath(config *util.ExtensionConfig) (*util.ExtensionConfig, error) {
-	foundChart := ""
 
-	err := filepath.Walk(config.DirPath, func(filePath string, info os.FileInfo, err error) error {
-		// go through all the Dockerfiles inside kaapanaPath
+	foundCharts := []string{}
+	err = filepath.Walk(config.DirPath, func(filePath string, info os.FileInfo, err error) error {
+		// go through all the charts inside dirPath
 		if err != nil {
-			color.Red(err.Error())
 			return err
 		}
+		if info.IsDir() && config.OutputPath != "" && filePath == config.OutputPath {
+			return filepath.SkipDir
+		}
 
 		if !info.IsDir() && info.Name() == "Chart.yaml" {
 			if strings.Contains(filePath, "/charts/") {
 				color.Yellow("found sub-chart Chart.yaml file %s , skipping ", filePath)
 			} else {
 				color.Blue("found Chart.yaml in path %s", filePath)
-				foundChart = filePath
+				foundCharts = append(foundCharts, filepath.Dir(filePath))
 			}
 		}
 
@@ -126,101 +84,150 @@ func FindChartPath(config *util.ExtensionConfig) (*util.ExtensionConfig, error)
 	if err != nil {
 		return nil, err
 	}
-	config.ChartPath, _ = strings.CutSuffix(foundChart, "/Chart.yaml")
+	if len(foundCharts) == 0 {
+		return nil, errors.New("no Chart.yaml found under " + config.DirPath)
+	}
+	if len(foundCharts) > 1 {
+		color.Red("found %d charts under %s:", len(foundCharts), config.DirPath)
+		for _, chartPath := range foundCharts {
+			color.Red("- %s", chartPath)
+		}
+		return nil, errors.New("multiple charts found, set 'chart_paths' in the config file to the charts that should be packaged")
+	}
+	config.ChartPaths = foundCharts
 	return config, nil
 }
 
-func HandleRequirements(config *util.ExtensionConfig) error {
-	// check if requirements.yaml exists
-	reqYaml, err := findYamlInChartPath(config.ChartPath, "requirements.yaml")
+func HandleRequirements(config *util.ExtensionConfig) (err error) {
+	defer wrapError(config.ChartPath, &err)
+	// dependencies are read from Chart.yaml (apiVersion v2) or requirements.yaml (v1)
+	dependencies, err := readChartDependencies(config.ChartPath)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to read dependencies: %w", err)
 	}
-	if reqYaml == "" {
-		color.Magenta("No 'requirements.yaml' found under %s , skipped running 'helm dep up' this step", config.ChartPath)
+	if len(dependencies) == 0 {
+		color.Magenta("No dependencies found in chart %s , skipped this step", config.ChartPath)
 		return nil
 	}
-	// if exists, helm dep up and untar in place
-	color.Blue("running helm dep up %s --debug", config.ChartPath)
-	command := exec.Command("helm", "dep", "up", config.ChartPath, "--debug")
-	command.Stdout = os.Stdout
-	command.Stderr = os.Stderr
 
-	err = command.Run()
+	// dependencies that are part of Kaapana are packaged from kaapana_path
+	kaapanaCharts, err := findKaapanaCharts(config.KaapanaPath)
 	if err != nil {
-		return errors.New("failed to run 'helm dep up " + reqYaml + "': " + err.Error())
+		return fmt.Errorf("failed to search charts in kaapana_path %s: %w", config.KaapanaPath, err)
 	}
 
-	return nil
+	return resolveDependencies(config.ChartPath, kaapanaCharts, map[string]bool{})
 }
 
-func EditChartYaml(config *util.ExtensionConfig) error {
-	// Changes 'version' to config.KaapanaBuildVersion
+func EditChartYaml(config *util.ExtensionConfig) (err error) {
+	defer wrapError(config.ChartPath, &err)
+	// Changes 'version' to the chart version rendered from chart_version_template
 
 	// read file
 	chartFile := config.ChartPath + "/Chart.yaml"
-	chartYaml, err := yamlRead(chartFile)
+	chartYaml, err := readYamlDocument(chartFile)
 	if err != nil {
-		color.Red("failed to read Chart.yaml %s", chartFile)
-		return err
+		return fmt.Errorf("failed to read Chart.yaml: %w", err)
 	}
 
 	// change version
-	_, ok := chartYaml["version"]
-	if !ok {
-		color.Red("Chart.yaml must contain a 'version' key %s", chartFile)
-		return errors.New(chartFile + " does not have a 'version key")
+	if chartYaml.lookup([]string{"version"}) == nil {
+		return errors.New(chartFile + " does not have a 'version' key")
+	}
+	if err := chartYaml.setString([]string{"version"}, config.Versions.Chart); err != nil {
+		return err
 	}
-	chartYaml["version"] = config.KaapanaBuildVersion
 
 	// write back
-	err = yamlWrite(chartFile, chartYaml)
+	err = chartYaml.write()
 	if err != nil {
-		color.Red("failed to write to Chart.yaml %s", chartFile)
-		return err
+		return fmt.Errorf("failed to write to Chart.yaml: %w", err)
 	}
+	util.Emit(util.Event{Type: util.EventChartEdited, Chart: config.ChartPath, File: chartFile, Version: config.Versions.Chart})
 	return nil
 }
 
-func EditValuesYaml(config *util.ExtensionConfig) error {
+func EditValuesYaml(config *util.ExtensionConfig) (err error) {
+	defer wrapError(config.ChartPath, &err)
 	/* Adds
-	 * custom_registry_url: config.CustomRegistryUrl
-	 * pull_policy_images: IfNotPresent
+	 * global.custom_registry_url: config.CustomRegistryUrl
+	 * global.image_tag: the tag of the built images rendered from image_tag_template
+	 * global.pull_policy_images: IfNotPresent
+	 * and everything in config.ExtraValues
 	 */
 
 	// read file
-	valuesFile := config.ChartPath + "/values.yaml"
-	valuesYaml, err := yamlRead(valuesFile)
+	valuesYaml, err := OpenValuesFile(config.ChartPath)
 	if err != nil {
-		color.Red("failed to read Chart.yaml %s", valuesFile)
-		return err
+		return fmt.Errorf("failed to read values.yaml: %w", err)
 	}
 
 	// add keys & values
-	global := valuesYaml["global"].(map[string]interface{})
-	global["custom_registry_url"] = config.CustomRegistryUrl
-	global["pull_policy_images"] = "IfNotPresent"
-	valuesYaml["global"] = global
+	values := map[string]interface{}{
+		"global.custom_registry_url": config.CustomRegistryUrl,
+		"global.image_tag":           config.Versions.Image,
+		"global.pull_policy_images":  "IfNotPresent",
+	}
+	for path, value := range config.ExtraValues {
+		values[path] = value
+	}
+	if err := valuesYaml.SetAll(values); err != nil {
+		return err
+	}
 
 	// write back
-	err = yamlWrite(valuesFile, valuesYaml)
+	err = valuesYaml.Save()
 	if err != nil {
-		color.Red("failed to write to values.yaml %s", valuesFile)
-		return err
+		return fmt.Errorf("failed to write to values.yaml: %w", err)
 	}
+	util.Emit(util.Event{Type: util.EventChartEdited, Chart: config.ChartPath, File: valuesYaml.doc.path})
 	return nil
 }
 
-func PackageChart(config *util.ExtensionConfig) error {
-	color.Blue("running helm package %s -d %s --debug", config.ChartPath, config.ChartPath)
-	command := exec.Command("helm", "package", config.ChartPath, "-d", config.ChartPath, "--debug")
-	command.Stdout = os.Stdout
-	command.Stderr = os.Stderr
+func chartName(chartPath string) (string, error) {
+	metadata, err := chartutil.LoadChartfile(filepath.Join(chartPath, "Chart.yaml"))
+	if err != nil {
+		return "", err
+	}
+	return metadata.Name, nil
+}
+
+// ChartArtifactPath returns the path the chart in config.ChartPath is packaged to
+func ChartArtifactPath(config *util.ExtensionConfig) (string, error) {
+	name, err := chartName(config.ChartPath)
+	if err != nil {
+		return "", err
+	}
+	return util.ChartArtifactPath(config, name)
+}
 
-	err := command.Run()
+// PackageChart packages the chart into the output directory and returns the path of the created tgz
+func PackageChart(config *util.ExtensionConfig) (_ string, err error) {
+	defer wrapError(config.ChartPath, &err)
+	start := time.Now()
+	packaged, err := helmPackage(config.ChartPath, config.OutputPath)
 	if err != nil {
-		return errors.New("failed to run 'helm package " + config.ChartPath + "': " + err.Error())
+		return "", err
 	}
 
-	return nil
+	name, err := chartName(config.ChartPath)
+	if err != nil {
+		return "", err
+	}
+	artifactPath, err := util.ChartArtifactPath(config, name)
+	if err != nil {
+		return "", err
+	}
+	if artifactPath != packaged {
+		color.Blue("renaming %s to %s", packaged, artifactPath)
+		if err := os.Rename(packaged, artifactPath); err != nil {
+			return "", err
+		}
+	}
+	event := util.Event{Type: util.EventChartPackaged, Chart: config.ChartPath, Name: name, File: artifactPath, Version: config.Versions.Chart, DurationMS: util.Since(start)}
+	if info, err := os.Stat(artifactPath); err == nil {
+		event.Bytes = info.Size()
+	}
+	util.Emit(event)
+	return artifactPath, nil
 }
@@ -0,0 +1,537 @@
+package chart
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"extensionctl/util"
+	"flag"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/handlers"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"golang.org/x/crypto/openpgp" //nolint
+	"gopkg.in/yaml.v3"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+var update = flag.Bool("update", false, "update the expected files in testdata")
+
+func copyFixture(t *testing.T, name string) string {
+	t.Helper()
+	src := filepath.Join("testdata", name)
+	dst := filepath.Join(t.TempDir(), name)
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"Chart.yaml", "values.yaml"} {
+		content, err := os.ReadFile(filepath.Join(src, file))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dst, file), content, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dst
+}
+
+func copyDir(t *testing.T, src string, dst string) {
+	t.Helper()
+	err := filepath.WalkDir(src, func(path string, info os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, rel), content, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func compareExpected(t *testing.T, actualPath string, expectedPath string) {
+	t.Helper()
+	actual, err := os.ReadFile(actualPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		if err := os.WriteFile(expectedPath, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(expectedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != string(expected) {
+		t.Fatalf("%s differs from %s:\n%s", actualPath, expectedPath, actual)
+	}
+}
+
+func TestEditValuesYaml(t *testing.T) {
+	fixtures := []string{"global-existing", "global-missing", "global-null", "global-flow", "no-values",
+		"global-flow-null", "global-folded", "global-multiline-plain", "global-multiline-quoted"}
+	for _, fixture := range fixtures {
+		t.Run(fixture, func(t *testing.T) {
+			chartPath := copyFixture(t, fixture)
+			original, err := OpenValuesFile(chartPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			desc, hasDesc := original.Get("global.desc")
+			config := &util.ExtensionConfig{
+				ChartPath:         chartPath,
+				CustomRegistryUrl: "registry.example.com/group/project",
+				Versions:          util.BuildVersions{Image: "0.1.0-kaapana0.2.2"},
+				ExtraValues: map[string]interface{}{
+					"global.registry_secret":   "registry-secret",
+					"global.resources.gpu.max": "1",
+				},
+			}
+			if err := EditValuesYaml(config); err != nil {
+				t.Fatalf("failed to edit values.yaml: %v", err)
+			}
+			compareExpected(t, filepath.Join(chartPath, "values.yaml"), filepath.Join("testdata", fixture, "values.expected.yaml"))
+
+			values, err := OpenValuesFile(chartPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value, ok := values.Get("global.custom_registry_url"); !ok || value != config.CustomRegistryUrl {
+				t.Fatalf("unexpected global.custom_registry_url '%s'", value)
+			}
+			if value, ok := values.Get("global.image_tag"); !ok || value != config.Versions.Image {
+				t.Fatalf("unexpected global.image_tag '%s'", value)
+			}
+			if value, ok := values.Get("global.resources.gpu.max"); !ok || value != "1" {
+				t.Fatalf("unexpected global.resources.gpu.max '%s'", value)
+			}
+			if value, ok := values.Get("global.desc"); ok != hasDesc || value != desc {
+				t.Fatalf("global.desc changed from '%s' to '%s'", desc, value)
+			}
+		})
+	}
+}
+
+func TestEditValuesYamlScalarGlobal(t *testing.T) {
+	chartPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(chartPath, "values.yaml"), []byte("global: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := &util.ExtensionConfig{ChartPath: chartPath, CustomRegistryUrl: "docker.io/kaapana"}
+	if err := EditValuesYaml(config); err == nil {
+		t.Fatalf("expected an error for a scalar 'global'")
+	}
+}
+
+func TestSetFlowNull(t *testing.T) {
+	chartPath := t.TempDir()
+	valuesPath := filepath.Join(chartPath, "values.yaml")
+	if err := os.WriteFile(valuesPath, []byte("x: {a: ~, b: 1}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	values, err := OpenValuesFile(chartPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := values.Set("x.a.c", "value"); err != nil {
+		t.Fatalf("failed to set x.a.c: %v", err)
+	}
+	if err := values.Save(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(valuesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "x: {a: {c: value}, b: 1}\n" {
+		t.Fatalf("unexpected values.yaml:\n%s", content)
+	}
+}
+
+func TestEditChartYaml(t *testing.T) {
+	chartPath := copyFixture(t, "global-existing")
+	config := &util.ExtensionConfig{
+		ChartPath: chartPath,
+		Versions:  util.BuildVersions{Chart: "1.0"},
+	}
+	if err := EditChartYaml(config); err != nil {
+		t.Fatalf("failed to edit Chart.yaml: %v", err)
+	}
+	compareExpected(t, filepath.Join(chartPath, "Chart.yaml"), filepath.Join("testdata", "global-existing", "Chart.expected.yaml"))
+
+	info, err := os.Stat(filepath.Join(chartPath, "Chart.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Fatalf("expected permissions of Chart.yaml to be kept, got %o", info.Mode().Perm())
+	}
+}
+
+func TestHandleRequirements(t *testing.T) {
+	tmp := t.TempDir()
+	kaapanaPath := filepath.Join(tmp, "kaapana")
+	chartPath := filepath.Join(tmp, "extension", "workflow-chart")
+	copyDir(t, filepath.Join("testdata", "kaapana"), kaapanaPath)
+	copyDir(t, filepath.Join("testdata", "workflow-chart"), chartPath)
+
+	// the relative file:// path only exists inside the Kaapana repository, the
+	// dependency has to be found by its name in kaapana_path instead
+	config := &util.ExtensionConfig{
+		ChartPath:   chartPath,
+		KaapanaPath: kaapanaPath,
+		OutputPath:  filepath.Join(tmp, "dist"),
+		Versions:    util.BuildVersions{Chart: "0.0.0"},
+	}
+	if err := HandleRequirements(config); err != nil {
+		t.Fatalf("failed to handle requirements: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(chartPath, "charts", "dag-installer-chart-0.0.0.tgz")); err != nil {
+		t.Fatalf("dependency was not packaged: %v", err)
+	}
+
+	packaged, err := PackageChart(config)
+	if err != nil {
+		t.Fatalf("failed to package chart: %v", err)
+	}
+	if packaged != filepath.Join(tmp, "dist", "workflow-chart-0.0.0.tgz") {
+		t.Fatalf("unexpected package %s", packaged)
+	}
+}
+
+func TestLockedVersion(t *testing.T) {
+	lock := &helmchart.Lock{Dependencies: []*helmchart.Dependency{
+		{Name: "redis", Repository: "https://charts.example.com", Version: "17.3.2"},
+	}}
+	tests := []struct {
+		dependency helmchart.Dependency
+		expected   string
+	}{
+		{helmchart.Dependency{Name: "redis", Repository: "https://charts.example.com", Version: "^17.0.0"}, "17.3.2"},
+		{helmchart.Dependency{Name: "redis", Repository: "https://charts.example.com", Version: "^18.0.0"}, ""},
+		{helmchart.Dependency{Name: "redis", Repository: "https://other.example.com", Version: "^17.0.0"}, ""},
+		{helmchart.Dependency{Name: "postgres", Repository: "https://charts.example.com", Version: "*"}, ""},
+	}
+	for _, test := range tests {
+		if version := lockedVersion(lock, &test.dependency); version != test.expected {
+			t.Errorf("expected %q for %s %s from %s, got %q", test.expected, test.dependency.Name, test.dependency.Version, test.dependency.Repository, version)
+		}
+	}
+}
+
+func TestHandleRequirementsVersionConstraint(t *testing.T) {
+	tmp := t.TempDir()
+	kaapanaPath := filepath.Join(tmp, "kaapana")
+	chartPath := filepath.Join(tmp, "extension", "workflow-chart")
+	copyDir(t, filepath.Join("testdata", "kaapana"), kaapanaPath)
+	copyDir(t, filepath.Join("testdata", "workflow-chart"), chartPath)
+
+	chartYaml := filepath.Join(chartPath, "Chart.yaml")
+	content, err := os.ReadFile(chartYaml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = []byte(strings.Replace(string(content), "version: 0.0.0\n    repository", "version: \">=1.0.0\"\n    repository", 1))
+	if err := os.WriteFile(chartYaml, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &util.ExtensionConfig{ChartPath: chartPath, KaapanaPath: kaapanaPath, Versions: util.BuildVersions{Chart: "0.0.0"}}
+	if err := HandleRequirements(config); err == nil {
+		t.Fatal("expected the version constraint of the Kaapana chart to be checked")
+	}
+}
+
+func TestPackageIntoChartFolder(t *testing.T) {
+	chartPath := copyFixture(t, "global-existing")
+	outputPath := filepath.Join(chartPath, "dist")
+	if err := os.MkdirAll(outputPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outputPath, "images.tar"), []byte("images"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	packaged, err := helmPackage(chartPath, outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err := loader.Load(packaged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range ch.Files {
+		if strings.HasPrefix(file.Name, "dist/") {
+			t.Errorf("output directory was packaged into the chart: %s", file.Name)
+		}
+	}
+}
+
+func TestRenderImages(t *testing.T) {
+	tmp := t.TempDir()
+	kaapanaPath := filepath.Join(tmp, "kaapana")
+	chartPath := filepath.Join(tmp, "extension", "workflow-chart")
+	copyDir(t, filepath.Join("testdata", "kaapana"), kaapanaPath)
+	copyDir(t, filepath.Join("testdata", "workflow-chart"), chartPath)
+
+	config := &util.ExtensionConfig{
+		ChartPath:           chartPath,
+		KaapanaPath:         kaapanaPath,
+		CustomRegistryUrl:   "registry.example.com/group/project",
+		KaapanaBuildVersion: "0.2.2",
+	}
+	if err := HandleRequirements(config); err != nil {
+		t.Fatal(err)
+	}
+	references, err := RenderImages(config)
+	if err != nil {
+		t.Fatalf("failed to render images: %v", err)
+	}
+	if len(references) != 1 || references[0].Image != "registry.example.com/group/project/workflow-dag:0.2.2" || references[0].Kind != "Job" {
+		t.Fatalf("unexpected image references %+v", references)
+	}
+}
+
+func TestFindChartPaths(t *testing.T) {
+	dirPath := t.TempDir()
+	copyDir(t, filepath.Join("testdata", "workflow-chart"), filepath.Join(dirPath, "extension", "workflow-chart"))
+
+	config, err := FindChartPaths(&util.ExtensionConfig{DirPath: dirPath})
+	if err != nil {
+		t.Fatalf("failed to find chart: %v", err)
+	}
+	if len(config.ChartPaths) != 1 || config.ChartPaths[0] != filepath.Join(dirPath, "extension", "workflow-chart") {
+		t.Fatalf("unexpected chart paths %s", config.ChartPaths)
+	}
+
+	copyDir(t, filepath.Join("testdata", "global-existing"), filepath.Join(dirPath, "service-chart"))
+	if _, err := FindChartPaths(&util.ExtensionConfig{DirPath: dirPath}); err == nil {
+		t.Fatalf("expected an error for multiple discovered charts")
+	}
+
+	explicit := []string{filepath.Join(dirPath, "extension", "workflow-chart"), filepath.Join(dirPath, "service-chart")}
+	config, err = FindChartPaths(&util.ExtensionConfig{DirPath: dirPath, ChartPaths: explicit})
+	if err != nil || len(config.ChartPaths) != 2 {
+		t.Fatalf("expected explicit chart paths to be used, got %s: %v", config.ChartPaths, err)
+	}
+}
+
+func TestSignChart(t *testing.T) {
+	tmp := t.TempDir()
+	entity, err := openpgp.NewEntity("extensionctl test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := filepath.Join(tmp, "secring.gpg")
+	f, err := os.Create(keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SerializePrivate(f, nil); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	packaged, err := helmPackage(copyFixture(t, "global-existing"), tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := util.LoadSigner(util.SignOptions{Key: "extensionctl test", Keyring: keyring})
+	if err != nil {
+		t.Fatalf("failed to load signer: %v", err)
+	}
+	if _, err := SignChart(packaged, signer); err != nil {
+		t.Fatalf("failed to sign chart: %v", err)
+	}
+	if _, err := VerifyChart(packaged, keyring); err != nil {
+		t.Fatalf("failed to verify chart: %v", err)
+	}
+
+	if err := os.WriteFile(packaged, []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyChart(packaged, keyring); err == nil {
+		t.Fatalf("expected verification of a modified chart to fail")
+	}
+}
+
+func TestUpdateRepoIndex(t *testing.T) {
+	tmp := t.TempDir()
+	repoDir := filepath.Join(tmp, "repo")
+
+	packaged, err := helmPackage(copyFixture(t, "global-existing"), tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an entry of another chart that already is in the index has to be kept
+	existing := repo.NewIndexFile()
+	if err := existing.MustAdd(&helmchart.Metadata{APIVersion: "v2", Name: "other", Version: "1.0.0"}, "other-1.0.0.tgz", "", "sha256:0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := existing.WriteFile(filepath.Join(repoDir, RepoIndexFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := UpdateRepoIndex(packaged, repoDir, "https://charts.example.com/kaapana"); err != nil {
+			t.Fatalf("failed to update index: %v", err)
+		}
+	}
+
+	index, err := repo.LoadIndexFile(filepath.Join(repoDir, RepoIndexFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !index.Has("other", "1.0.0") {
+		t.Fatalf("existing index entry was removed: %v", index.Entries)
+	}
+	ch, err := loader.Load(packaged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions := index.Entries[ch.Metadata.Name]
+	if len(versions) != 1 {
+		t.Fatalf("expected one entry for %s, got %d", ch.Metadata.Name, len(versions))
+	}
+	wantURL := "https://charts.example.com/kaapana/" + filepath.Base(packaged)
+	if versions[0].URLs[0] != wantURL {
+		t.Fatalf("expected url %s, got %s", wantURL, versions[0].URLs[0])
+	}
+	if _, err := os.Stat(filepath.Join(repoDir, filepath.Base(packaged))); err != nil {
+		t.Fatalf("chart was not copied into the repository: %v", err)
+	}
+}
+
+func TestPushChart(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.Storage = configuration.Storage{"inmemory": configuration.Parameters{}}
+	config.Log.Level = "error"
+	app := handlers.NewApp(context.Background(), config)
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	tmp := t.TempDir()
+	packaged, err := helmPackage(copyFixture(t, "global-existing"), tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := strings.TrimPrefix(server.URL, "http://")
+	ref, err := PushChart(packaged, "oci://"+host+"/kaapana", true)
+	if err != nil {
+		t.Fatalf("failed to push chart: %v", err)
+	}
+
+	ch, err := loader.Load(packaged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := host + "/kaapana/" + ch.Metadata.Name + ":" + ch.Metadata.Version
+	if ref != want {
+		t.Fatalf("expected ref %s, got %s", want, ref)
+	}
+	client, err := registry.NewClient(registry.ClientOptPlainHTTP(), registry.ClientOptWriter(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err := client.Tags(host + "/kaapana/" + ch.Metadata.Name)
+	if err != nil {
+		t.Fatalf("failed to list tags: %v", err)
+	}
+	if len(tags) != 1 || tags[0] != ch.Metadata.Version {
+		t.Fatalf("expected tag %s, got %v", ch.Metadata.Version, tags)
+	}
+
+	if _, err := PushChart(packaged, "https://"+host, true); err == nil {
+		t.Fatalf("expected push to a non oci:// target to fail")
+	}
+}
+
+func TestEditChartYamlError(t *testing.T) {
+	chartPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(chartPath, "Chart.yaml"), []byte("apiVersion: v2\nname: otsus-method\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := EditChartYaml(&util.ExtensionConfig{ChartPath: chartPath, Versions: util.BuildVersions{Chart: "1.0"}})
+	var chartErr *ChartError
+	if !errors.As(err, &chartErr) || chartErr.Chart != chartPath {
+		t.Fatalf("expected a ChartError for %s, got %v", chartPath, err)
+	}
+}
+
+func TestEditValuesYamlTypedExtraValues(t *testing.T) {
+	chartPath := copyFixture(t, "extra-values-typed")
+	// the types of the values are the ones of the json config file
+	var config util.ExtensionConfig
+	if err := json.Unmarshal([]byte(`{"extra_values": {
+		"global.gpu_support": true,
+		"global.replicas": 2,
+		"global.resources.memory": 1.5,
+		"global.resources.gpu.max": 1,
+		"global.debug": false,
+		"global.tag": "1",
+		"global.node_selector": null
+	}}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	config.ChartPath = chartPath
+	config.CustomRegistryUrl = "registry.example.com/group/project"
+	config.Versions = util.BuildVersions{Image: "0.1.0"}
+	if err := EditValuesYaml(&config); err != nil {
+		t.Fatalf("failed to edit values.yaml: %v", err)
+	}
+	compareExpected(t, filepath.Join(chartPath, "values.yaml"), filepath.Join("testdata", "extra-values-typed", "values.expected.yaml"))
+
+	content, err := os.ReadFile(filepath.Join(chartPath, "values.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values struct {
+		Global map[string]interface{} `yaml:"global"`
+	}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"gpu_support": true, "replicas": 2, "debug": false, "tag": "1", "node_selector": nil,
+		"resources": map[string]interface{}{"cpu": 1, "memory": 1.5, "gpu": map[string]interface{}{"max": 1}},
+	}
+	for key, value := range expected {
+		if !reflect.DeepEqual(values.Global[key], value) {
+			t.Errorf("expected global.%s to be %#v, got %#v", key, value, values.Global[key])
+		}
+	}
+
+	config.ExtraValues = map[string]interface{}{"global.list": []interface{}{"a"}}
+	if err := EditValuesYaml(&config); err == nil {
+		t.Fatalf("expected an error for a list in extra_values")
+	}
+}
@@ -0,0 +1,263 @@
+package chart
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/fatih/color"
+	"helm.sh/helm/v3/pkg/action"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/downloader"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/registry"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// readChartDependencies returns the dependencies of a chart. The loader reads them from
+// Chart.yaml for apiVersion v2 charts and from requirements.yaml for legacy v1 charts.
+func readChartDependencies(chartPath string) ([]*helmchart.Dependency, error) {
+	ch, err := loader.LoadDir(chartPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart %s: %w", chartPath, err)
+	}
+	return ch.Metadata.Dependencies, nil
+}
+
+// findKaapanaCharts maps chart names to their folders inside kaapanaPath
+func findKaapanaCharts(kaapanaPath string) (map[string]string, error) {
+	charts := map[string]string{}
+	err := filepath.WalkDir(kaapanaPath, func(filePath string, info os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && (info.Name() == ".git" || info.Name() == "node_modules") {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || info.Name() != "Chart.yaml" || strings.Contains(filePath, "/charts/") {
+			return nil
+		}
+
+		chartPath := filepath.Dir(filePath)
+		metadata, err := chartutil.LoadChartfile(filePath)
+		if err != nil {
+			color.Yellow("skipping %s: %s", filePath, err.Error())
+			return nil
+		}
+		if existing, ok := charts[metadata.Name]; ok {
+			color.Yellow("chart %s exists in both %s and %s, using the first one", metadata.Name, existing, chartPath)
+			return nil
+		}
+		charts[metadata.Name] = chartPath
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return charts, nil
+}
+
+// resolveDependencies puts every dependency of the chart as a packaged tgz into its
+// charts/ folder. Local file:// charts and charts that exist in the Kaapana repository are
+// referenced by their folder, then the helm dependency manager packages or pulls all of
+// them, checking the version constraints and keeping the versions pinned in Chart.lock.
+func resolveDependencies(chartPath string, kaapanaCharts map[string]string, visited map[string]bool) error {
+	if visited[chartPath] {
+		return nil
+	}
+	visited[chartPath] = true
+
+	ch, err := loader.LoadDir(chartPath)
+	if err != nil {
+		return fmt.Errorf("failed to load chart %s: %w", chartPath, err)
+	}
+	if len(ch.Metadata.Dependencies) == 0 {
+		return nil
+	}
+
+	dependencies := make([]*helmchart.Dependency, 0, len(ch.Metadata.Dependencies))
+	remote := false
+	for _, dependency := range ch.Metadata.Dependencies {
+		resolved := *dependency
+		if localPath := localDependencyPath(chartPath, dependency, kaapanaCharts); localPath != "" {
+			color.Blue("packaging dependency %s from %s", dependency.Name, localPath)
+			if err := resolveDependencies(localPath, kaapanaCharts, visited); err != nil {
+				return err
+			}
+			resolved.Repository = "file://" + localPath
+		} else if dependency.Repository == "" || strings.HasPrefix(dependency.Repository, "file://") {
+			return errors.New("dependency " + dependency.Name + " is neither available locally nor in kaapana_path")
+		} else {
+			remote = true
+			if version := lockedVersion(ch.Lock, dependency); version != "" {
+				resolved.Version = version
+			}
+		}
+		dependencies = append(dependencies, &resolved)
+	}
+	return updateDependencies(ch, chartPath, dependencies, remote)
+}
+
+// localDependencyPath returns the folder of a dependency that is available locally, either
+// through its file:// repository or by its name in the Kaapana repository
+func localDependencyPath(chartPath string, dependency *helmchart.Dependency, kaapanaCharts map[string]string) string {
+	if strings.HasPrefix(dependency.Repository, "file://") {
+		localPath := strings.TrimPrefix(dependency.Repository, "file://")
+		if !filepath.IsAbs(localPath) {
+			localPath = filepath.Join(chartPath, localPath)
+		}
+		if isChartFolder(localPath) {
+			return localPath
+		}
+		color.Yellow("dependency %s is not available at %s", dependency.Name, localPath)
+	}
+	return kaapanaCharts[dependency.Name]
+}
+
+// lockedVersion returns the version Chart.lock (requirements.lock for v1 charts) pins the
+// dependency to, if it still satisfies the version constraint of the dependency
+func lockedVersion(lock *helmchart.Lock, dependency *helmchart.Dependency) string {
+	if lock == nil {
+		return ""
+	}
+	for _, locked := range lock.Dependencies {
+		if locked.Name != dependency.Name || locked.Repository != dependency.Repository {
+			continue
+		}
+		constraint, err := semver.NewConstraint(dependency.Version)
+		if err != nil {
+			return ""
+		}
+		version, err := semver.NewVersion(locked.Version)
+		if err != nil || !constraint.Check(version) {
+			color.Yellow("locked version %s of dependency %s does not satisfy %s, run 'helm dependency update'", locked.Version, dependency.Name, dependency.Version)
+			return ""
+		}
+		return locked.Version
+	}
+	return ""
+}
+
+// updateDependencies runs the helm dependency manager on a copy of the chart metadata with
+// the resolved dependencies, so that Chart.yaml and Chart.lock of the chart are not changed,
+// and moves the packaged dependencies into the charts/ folder of the chart
+func updateDependencies(ch *helmchart.Chart, chartPath string, dependencies []*helmchart.Dependency, remote bool) error {
+	tmp, err := os.MkdirTemp("", "extensionctl-dependencies-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	metadata := *ch.Metadata
+	metadata.APIVersion = helmchart.APIVersionV2
+	metadata.Dependencies = dependencies
+	content, err := sigsyaml.Marshal(&metadata)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "Chart.yaml"), content, 0644); err != nil {
+		return err
+	}
+
+	settings := cli.New()
+	registryClient, err := registry.NewClient(registry.ClientOptCredentialsFile(settings.RegistryConfig), registry.ClientOptWriter(color.Output))
+	if err != nil {
+		return err
+	}
+	manager := downloader.Manager{
+		Out:              color.Output,
+		ChartPath:        tmp,
+		Getters:          getter.All(settings),
+		RegistryClient:   registryClient,
+		RepositoryConfig: settings.RepositoryConfig,
+		RepositoryCache:  settings.RepositoryCache,
+		// the configured repositories only have to be updated for remote dependencies
+		SkipUpdate: !remote,
+	}
+	if err := manager.Update(); err != nil {
+		return fmt.Errorf("failed to update dependencies: %w", err)
+	}
+
+	chartsDir := filepath.Join(chartPath, "charts")
+	if err := os.MkdirAll(chartsDir, 0755); err != nil {
+		return err
+	}
+	for _, dependency := range dependencies {
+		if err := removePackagedDependency(chartsDir, dependency.Name); err != nil {
+			return err
+		}
+	}
+	packages, err := filepath.Glob(filepath.Join(tmp, "charts", "*.tgz"))
+	if err != nil {
+		return err
+	}
+	for _, pkg := range packages {
+		content, err := os.ReadFile(pkg)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(chartsDir, filepath.Base(pkg)), content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removePackagedDependency deletes older packages of the dependency so that only one version ends up in the chart
+func removePackagedDependency(chartsDir string, name string) error {
+	matches, err := filepath.Glob(filepath.Join(chartsDir, name+"-*.tgz"))
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		version := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), name+"-"), ".tgz")
+		if version == "" || version[0] < '0' || version[0] > '9' {
+			// belongs to another chart with the same prefix
+			continue
+		}
+		color.Yellow("removing previously packaged dependency %s", match)
+		if err := os.Remove(match); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// helmPackage packages the chart in chartPath into destination and returns the path of the tgz.
+// If destination is inside the chart (e.g. the default <dir_path>/dist of a chart in dir_path),
+// the artifacts in it are left out of the package.
+func helmPackage(chartPath string, destination string) (string, error) {
+	color.Blue("packaging chart %s into %s", chartPath, destination)
+	ch, err := loader.LoadDir(chartPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to load chart %s: %w", chartPath, err)
+	}
+	if rel, err := filepath.Rel(chartPath, destination); err == nil && filepath.IsLocal(rel) {
+		prefix := filepath.ToSlash(rel) + "/"
+		files := ch.Files[:0]
+		for _, file := range ch.Files {
+			if !strings.HasPrefix(file.Name, prefix) {
+				files = append(files, file)
+			}
+		}
+		ch.Files = files
+	}
+	if dependencies := ch.Metadata.Dependencies; dependencies != nil {
+		if err := action.CheckDependencies(ch, dependencies); err != nil {
+			return "", fmt.Errorf("failed to package chart %s: %w", chartPath, err)
+		}
+	}
+
+	packaged, err := chartutil.Save(ch, destination)
+	if err != nil {
+		return "", fmt.Errorf("failed to package chart %s: %w", chartPath, err)
+	}
+	color.Blue("packaged chart %s", packaged)
+	return packaged, nil
+}
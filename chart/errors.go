@@ -0,0 +1,39 @@
+package chart
+
+import (
+	"errors"
+	"extensionctl/util"
+)
+
+// ChartError is a failure to prepare, package, render or publish a chart. Chart is the chart
+// folder or the packaged chart, empty if the error is not about a single chart.
+type ChartError struct {
+	Chart string
+	Err   error
+}
+
+func (e *ChartError) Error() string {
+	if e.Chart == "" {
+		return "chart: " + e.Err.Error()
+	}
+	return "chart " + e.Chart + ": " + e.Err.Error()
+}
+
+func (e *ChartError) Unwrap() error {
+	return e.Err
+}
+
+// wrapError is deferred by the exported functions to return a ChartError, errors of the config
+// and the cluster are returned as they are
+func wrapError(chart string, err *error) {
+	if *err == nil {
+		return
+	}
+	var chartErr *ChartError
+	var configErr *util.ConfigError
+	var clusterErr *util.ClusterError
+	if errors.As(*err, &chartErr) || errors.As(*err, &configErr) || errors.As(*err, &clusterErr) {
+		return
+	}
+	*err = &ChartError{Chart: chart, Err: *err}
+}
@@ -0,0 +1,146 @@
+package chart
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/registry"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// RepoIndexFile is the name of the index of a static chart repository
+const RepoIndexFile = "index.yaml"
+
+// PushChart pushes a packaged chart (and its provenance file if it exists) to an OCI registry,
+// remote has the form oci://registry/project. It returns the pushed reference.
+func PushChart(packaged string, remote string, plainHTTP bool) (_ string, err error) {
+	defer wrapError(packaged, &err)
+	if !registry.IsOCI(remote) {
+		return "", fmt.Errorf("push target %s must start with %s://", remote, registry.OCIScheme)
+	}
+	ch, err := loader.Load(packaged)
+	if err != nil {
+		return "", fmt.Errorf("failed to load: %w", err)
+	}
+	data, err := os.ReadFile(packaged)
+	if err != nil {
+		return "", err
+	}
+
+	options := []registry.ClientOption{
+		registry.ClientOptCredentialsFile(cli.New().RegistryConfig),
+		registry.ClientOptWriter(io.Discard),
+	}
+	if plainHTTP {
+		options = append(options, registry.ClientOptPlainHTTP())
+	}
+	registryClient, err := registry.NewClient(options...)
+	if err != nil {
+		return "", err
+	}
+
+	pushOptions := []registry.PushOption{}
+	if prov, err := os.ReadFile(packaged + ".prov"); err == nil {
+		pushOptions = append(pushOptions, registry.PushOptProvData(prov))
+	}
+
+	ref := fmt.Sprintf("%s:%s",
+		path.Join(strings.TrimPrefix(remote, registry.OCIScheme+"://"), ch.Metadata.Name),
+		ch.Metadata.Version)
+	color.Blue("pushing chart %s to %s", packaged, ref)
+	result, err := registryClient.Push(data, ref, pushOptions...)
+	if err != nil {
+		return "", fmt.Errorf("failed to push to %s: %w", ref, err)
+	}
+	color.Blue("pushed %s with digest %s", result.Ref, result.Manifest.Digest)
+	return result.Ref, nil
+}
+
+// UpdateRepoIndex copies a packaged chart (and its provenance file) into repoDir and adds
+// it to repoDir/index.yaml. Existing entries are kept, an entry with the same name and
+// version is replaced. baseURL is prefixed to the chart urls, it can be empty for
+// repositories serving the index and the charts from the same location.
+func UpdateRepoIndex(packaged string, repoDir string, baseURL string) (_ string, err error) {
+	defer wrapError(packaged, &err)
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		return "", err
+	}
+	ch, err := loader.Load(packaged)
+	if err != nil {
+		return "", fmt.Errorf("failed to load: %w", err)
+	}
+
+	fileName := filepath.Base(packaged)
+	target := filepath.Join(repoDir, fileName)
+	for _, file := range []string{fileName, fileName + ".prov"} {
+		if _, err := os.Stat(filepath.Join(filepath.Dir(packaged), file)); err != nil {
+			continue
+		}
+		if err := copyFile(filepath.Join(filepath.Dir(packaged), file), filepath.Join(repoDir, file)); err != nil {
+			return "", err
+		}
+	}
+
+	indexPath := filepath.Join(repoDir, RepoIndexFile)
+	index := repo.NewIndexFile()
+	if _, err := os.Stat(indexPath); err == nil {
+		index, err = repo.LoadIndexFile(indexPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to load chart repository index %s: %w", indexPath, err)
+		}
+	}
+
+	// drop the entry of a chart version that is packaged again
+	name, version := ch.Metadata.Name, ch.Metadata.Version
+	versions := repo.ChartVersions{}
+	for _, entry := range index.Entries[name] {
+		if entry.Version != version {
+			versions = append(versions, entry)
+		}
+	}
+	index.Entries[name] = versions
+
+	digest, err := provenance.DigestFile(target)
+	if err != nil {
+		return "", err
+	}
+	if err := index.MustAdd(ch.Metadata, fileName, baseURL, digest); err != nil {
+		return "", fmt.Errorf("failed to add %s to %s: %w", fileName, indexPath, err)
+	}
+	index.SortEntries()
+	if err := index.WriteFile(indexPath, 0644); err != nil {
+		return "", err
+	}
+	color.Blue("added %s %s to chart repository index %s", name, version, indexPath)
+	return indexPath, nil
+}
+
+func copyFile(source string, destination string) error {
+	if sourceAbs, err := filepath.Abs(source); err == nil {
+		if destinationAbs, err := filepath.Abs(destination); err == nil && sourceAbs == destinationAbs {
+			return nil
+		}
+	}
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,153 @@
+package chart
+
+import (
+	"extensionctl/util"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/releaseutil"
+)
+
+// ImageReference is an image used by a rendered manifest
+type ImageReference struct {
+	Image    string
+	Template string
+	Kind     string
+	Name     string
+}
+
+// globalValues are the 'global' values the platform and extensionctl inject into the chart
+func globalValues(config *util.ExtensionConfig) map[string]interface{} {
+	global := map[string]interface{}{
+		"registry_url":          config.CustomRegistryUrl,
+		"custom_registry_url":   config.CustomRegistryUrl,
+		"kaapana_build_version": config.KaapanaBuildVersion,
+		"image_tag":             config.Versions.Image,
+		"pull_policy_images":    "IfNotPresent",
+	}
+	values := map[string]interface{}{"global": global}
+	for path, value := range config.ExtraValues {
+		keys := strings.Split(path, ".")
+		current := values
+		for _, key := range keys[:len(keys)-1] {
+			next, ok := current[key].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				current[key] = next
+			}
+			current = next
+		}
+		current[keys[len(keys)-1]] = value
+	}
+	return values
+}
+
+// RenderImages renders the templates of the chart with the injected global values
+// and returns every container image the manifests refer to
+func RenderImages(config *util.ExtensionConfig) (_ []ImageReference, err error) {
+	defer wrapError(config.ChartPath, &err)
+	ch, err := loader.Load(config.ChartPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load: %w", err)
+	}
+
+	options := chartutil.ReleaseOptions{Name: ch.Name(), Namespace: "default", Revision: 1, IsInstall: true}
+	values, err := chartutil.ToRenderValues(ch, globalValues(config), options, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compose values: %w", err)
+	}
+	rendered, err := engine.Render(ch, values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render: %w", err)
+	}
+
+	templates := make([]string, 0, len(rendered))
+	for name := range rendered {
+		templates = append(templates, name)
+	}
+	sort.Strings(templates)
+
+	references := []ImageReference{}
+	for _, name := range templates {
+		if !strings.HasSuffix(name, ".yaml") && !strings.HasSuffix(name, ".yml") {
+			continue
+		}
+		for _, manifest := range releaseutil.SplitManifests(rendered[name]) {
+			var object map[string]interface{}
+			if err := yaml.Unmarshal([]byte(manifest), &object); err != nil {
+				return nil, fmt.Errorf("failed to parse rendered manifest in %s: %w", name, err)
+			}
+			if object == nil {
+				continue
+			}
+			kind, _ := object["kind"].(string)
+			metadata, _ := object["metadata"].(map[string]interface{})
+			objectName, _ := metadata["name"].(string)
+			for _, image := range manifestImages(object) {
+				references = append(references, ImageReference{Image: image, Template: name, Kind: kind, Name: objectName})
+			}
+		}
+	}
+	return references, nil
+}
+
+func nested(object map[string]interface{}, keys ...string) map[string]interface{} {
+	current := object
+	for _, key := range keys {
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = next
+	}
+	return current
+}
+
+// manifestImages returns the images of all containers in the pod spec of a workload,
+// including env variables that pass images on, e.g. to the DAG installer
+func manifestImages(object map[string]interface{}) []string {
+	var podSpec map[string]interface{}
+	switch object["kind"] {
+	case "Pod":
+		podSpec = nested(object, "spec")
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "Job":
+		podSpec = nested(object, "spec", "template", "spec")
+	case "CronJob":
+		podSpec = nested(object, "spec", "jobTemplate", "spec", "template", "spec")
+	}
+	if podSpec == nil {
+		return nil
+	}
+
+	images := []string{}
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _ := podSpec[field].([]interface{})
+		for _, c := range containers {
+			container, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if image, ok := container["image"].(string); ok && image != "" {
+				images = append(images, image)
+			}
+			env, _ := container["env"].([]interface{})
+			for _, e := range env {
+				envVar, ok := e.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				name, _ := envVar["name"].(string)
+				value, _ := envVar["value"].(string)
+				if strings.Contains(strings.ToUpper(name), "IMAGE") && strings.Contains(value, "/") && strings.Contains(value, ":") {
+					images = append(images, value)
+				}
+			}
+		}
+	}
+	return images
+}
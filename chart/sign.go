@@ -0,0 +1,43 @@
+package chart
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"helm.sh/helm/v3/pkg/provenance"
+)
+
+// SignChart writes the provenance file <chart>.tgz.prov for a packaged chart
+func SignChart(packaged string, signer *provenance.Signatory) (_ string, err error) {
+	defer wrapError(packaged, &err)
+	sig, err := signer.ClearSign(packaged)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign: %w", err)
+	}
+	provPath := packaged + ".prov"
+	if err := os.WriteFile(provPath, []byte(sig), 0644); err != nil {
+		return "", err
+	}
+	color.Blue("signed chart %s into %s", packaged, provPath)
+	return provPath, nil
+}
+
+// VerifyChart checks the provenance file of a packaged chart against the keys in keyring
+func VerifyChart(packaged string, keyring string) (_ *provenance.Verification, err error) {
+	defer wrapError(packaged, &err)
+	provPath := packaged + ".prov"
+	if _, err := os.Stat(provPath); err != nil {
+		return nil, errors.New("provenance file " + provPath + " does not exist")
+	}
+	verifier, err := provenance.NewFromKeyring(keyring, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load keyring %s: %w", keyring, err)
+	}
+	verification, err := verifier.Verify(packaged, provPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify: %w", err)
+	}
+	return verification, nil
+}
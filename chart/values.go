@@ -0,0 +1,87 @@
+package chart
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// ValuesFile is a values.yaml of a chart opened for editing. Values are addressed
+// by dotted paths such as 'global.registry_secret'.
+type ValuesFile struct {
+	doc *yamlDocument
+}
+
+// OpenValuesFile opens values.yaml in chartPath, the file is created on Save if it doesn't exist
+func OpenValuesFile(chartPath string) (*ValuesFile, error) {
+	valuesFile := filepath.Join(chartPath, "values.yaml")
+	if _, err := os.Stat(valuesFile); errors.Is(err, os.ErrNotExist) {
+		color.Yellow("%s does not exist, it will be created", valuesFile)
+		doc := &yamlDocument{path: valuesFile, mode: 0644}
+		if err := doc.parse(); err != nil {
+			return nil, err
+		}
+		return &ValuesFile{doc: doc}, nil
+	}
+
+	doc, err := readYamlDocument(valuesFile)
+	if err != nil {
+		return nil, err
+	}
+	return &ValuesFile{doc: doc}, nil
+}
+
+func splitValuePath(path string) ([]string, error) {
+	keys := strings.Split(path, ".")
+	for _, key := range keys {
+		if key == "" {
+			return nil, errors.New("invalid value path '" + path + "'")
+		}
+	}
+	return keys, nil
+}
+
+// Get returns the scalar value under path and whether it exists
+func (v *ValuesFile) Get(path string) (string, bool) {
+	keys, err := splitValuePath(path)
+	if err != nil {
+		return "", false
+	}
+	node := v.doc.lookup(keys)
+	if node == nil || isNull(node) || len(node.Content) > 0 {
+		return "", false
+	}
+	return node.Value, true
+}
+
+// Set sets path to value, a string, bool, number or nil, creating any missing parent mappings
+func (v *ValuesFile) Set(path string, value interface{}) error {
+	keys, err := splitValuePath(path)
+	if err != nil {
+		return err
+	}
+	return v.doc.setValue(keys, value)
+}
+
+// SetAll sets all values in a stable order
+func (v *ValuesFile) SetAll(values map[string]interface{}) error {
+	paths := make([]string, 0, len(values))
+	for path := range values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := v.Set(path, values[path]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *ValuesFile) Save() error {
+	return v.doc.write()
+}
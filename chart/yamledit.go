@@ -0,0 +1,652 @@
+package chart
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"extensionctl/util"
+
+	"github.com/fatih/color"
+	"gopkg.in/yaml.v3"
+)
+
+// yamlDocument edits a yaml file in place. The node tree is only used to locate
+// keys, the changes are spliced into the original bytes so that comments, key order
+// and formatting of everything else are kept as they are.
+type yamlDocument struct {
+	path    string
+	mode    os.FileMode
+	content []byte
+	root    *yaml.Node
+}
+
+func readYamlDocument(yamlPath string) (*yamlDocument, error) {
+	color.Blue("reading from yaml file %s", yamlPath)
+
+	info, err := os.Stat(yamlPath)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(yamlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &yamlDocument{path: yamlPath, mode: info.Mode().Perm(), content: content}
+	if err := doc.parse(); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (d *yamlDocument) parse() error {
+	var node yaml.Node
+	if err := yaml.Unmarshal(d.content, &node); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", d.path, err)
+	}
+	if node.Kind != yaml.DocumentNode || len(node.Content) == 0 || isNull(node.Content[0]) {
+		// empty file, keys are appended at the end
+		d.root = &yaml.Node{Kind: yaml.MappingNode}
+		return nil
+	}
+	if node.Content[0].Kind != yaml.MappingNode {
+		return errors.New(d.path + " does not contain a mapping at the top level")
+	}
+	d.root = node.Content[0]
+	return nil
+}
+
+func (d *yamlDocument) write() error {
+	color.Blue("writing to yaml file %s", d.path)
+	// keep the permissions of the original file
+	if err := util.WriteFileAtomic(d.path, d.content, d.mode); err != nil {
+		return err
+	}
+	color.Blue("successfully written to %s", d.path)
+	return nil
+}
+
+// lookup returns the value node under keys, nil if any of the keys does not exist
+func (d *yamlDocument) lookup(keys []string) *yaml.Node {
+	node := d.root
+	for _, key := range keys {
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		_, node = mappingValue(node, key)
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// setString sets keys to the string value
+func (d *yamlDocument) setString(keys []string, value string) error {
+	return d.setValue(keys, value)
+}
+
+// setValue sets keys to value, a string, bool, number or nil. Missing keys are added to their
+// mapping, missing or null parents are created as block mappings, or as flow mappings inside
+// flow mappings.
+func (d *yamlDocument) setValue(keys []string, value interface{}) error {
+	name := strings.Join(keys, ".")
+	var err error
+	node := d.root
+walk:
+	for i, key := range keys {
+		keyNode, valueNode := mappingValue(node, key)
+		switch {
+		case valueNode == nil:
+			err = d.insertKey(node, keys[i:], value)
+		case isNull(valueNode) && node.Style&yaml.FlowStyle != 0:
+			err = d.replaceFlowNull(keyNode, valueNode, keys[i+1:], value)
+		case isNull(valueNode):
+			err = d.replaceNull(keyNode, keys[i+1:], value)
+		case i == len(keys)-1:
+			err = d.replaceScalar(valueNode, value)
+		case valueNode.Kind != yaml.MappingNode:
+			err = fmt.Errorf("'%s' is not a mapping", strings.Join(keys[:i+1], "."))
+		default:
+			node = valueNode
+			continue
+		}
+		break walk
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set '%s' in %s: %w", name, d.path, err)
+	}
+	color.Blue("set '%s' to '%v' in %s", name, value, d.path)
+	return d.parse()
+}
+
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null"
+}
+
+func mappingValue(mapping *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i], mapping.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// offset converts the 1-based line and column of a node into a byte offset of content
+func (d *yamlDocument) offset(line int, column int) (int, error) {
+	pos := 0
+	for l := 1; l < line; l++ {
+		i := bytes.IndexByte(d.content[pos:], '\n')
+		if i < 0 {
+			return 0, fmt.Errorf("line %d is out of range", line)
+		}
+		pos += i + 1
+	}
+	for c := 1; c < column; c++ {
+		if pos >= len(d.content) || d.content[pos] == '\n' {
+			return 0, fmt.Errorf("column %d in line %d is out of range", column, line)
+		}
+		_, size := utf8.DecodeRune(d.content[pos:])
+		pos += size
+	}
+	return pos, nil
+}
+
+// splice replaces content between start and end with text. Line breaks in text are written
+// as CRLF if the file uses them.
+func (d *yamlDocument) splice(start int, end int, text string) {
+	if bytes.Contains(d.content, []byte("\r\n")) {
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	}
+	content := make([]byte, 0, len(d.content)+len(text))
+	content = append(content, d.content[:start]...)
+	content = append(content, text...)
+	content = append(content, d.content[end:]...)
+	d.content = content
+}
+
+func (d *yamlDocument) replaceScalar(node *yaml.Node, value interface{}) error {
+	if node.Kind != yaml.ScalarNode {
+		return errors.New("existing value is not a scalar")
+	}
+	if node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		return errors.New("block scalars can not be edited")
+	}
+	start, err := d.offset(node.Line, node.Column)
+	if err != nil {
+		return err
+	}
+	if start < len(d.content) && (d.content[start] == '&' || d.content[start] == '!' || d.content[start] == '*') {
+		return errors.New("values with anchors, aliases or tags can not be edited")
+	}
+	end, err := d.scalarEnd(node, start)
+	if err != nil {
+		return err
+	}
+	formatted, err := formatValue(value, node.Style)
+	if err != nil {
+		return err
+	}
+	d.splice(start, end, formatted)
+	return nil
+}
+
+// scalarEnd returns the offset right after the scalar starting at start
+func (d *yamlDocument) scalarEnd(node *yaml.Node, start int) (int, error) {
+	switch {
+	case node.Style&yaml.DoubleQuotedStyle != 0:
+		for i := start + 1; i < len(d.content); i++ {
+			if d.content[i] == '\\' {
+				i++
+			} else if d.content[i] == '"' {
+				return i + 1, nil
+			}
+		}
+		return 0, errors.New("unterminated double quoted scalar")
+	case node.Style&yaml.SingleQuotedStyle != 0:
+		for i := start + 1; i < len(d.content); i++ {
+			if d.content[i] != '\'' {
+				continue
+			}
+			if i+1 < len(d.content) && d.content[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1, nil
+		}
+		return 0, errors.New("unterminated single quoted scalar")
+	}
+
+	// single line plain scalars appear in the file exactly as their value
+	if bytes.HasPrefix(d.content[start:], []byte(node.Value)) {
+		return start + len(node.Value), nil
+	}
+	return d.multiLinePlainEnd(node, start)
+}
+
+// multiLinePlainEnd returns the offset right after a plain scalar that continues over
+// several lines. The lines are matched against the value, in which yaml folded each line
+// break into a space and each empty line into a newline.
+func (d *yamlDocument) multiLinePlainEnd(node *yaml.Node, start int) (int, error) {
+	rest := node.Value
+	pos := start
+	for {
+		lineEnd := len(d.content)
+		if i := bytes.IndexByte(d.content[pos:], '\n'); i >= 0 {
+			lineEnd = pos + i
+		}
+		line := d.content[pos:lineEnd]
+		if i := commentStart(line); i >= 0 {
+			line = line[:i]
+		}
+		text := bytes.TrimRight(line, " \t\r")
+		if trimmed := bytes.TrimLeft(text, " \t"); len(trimmed) > 0 {
+			if !strings.HasPrefix(rest, string(trimmed)) {
+				return 0, errors.New("failed to locate the end of a multi-line plain scalar")
+			}
+			rest = strings.TrimLeft(rest[len(trimmed):], " \n")
+			if rest == "" {
+				return pos + len(text), nil
+			}
+		}
+		if lineEnd == len(d.content) {
+			return 0, errors.New("failed to locate the end of a multi-line plain scalar")
+		}
+		pos = lineEnd + 1
+	}
+}
+
+// commentStart returns the index of a comment in line, -1 if there is none
+func commentStart(line []byte) int {
+	for i, c := range line {
+		if c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			return i
+		}
+	}
+	return -1
+}
+
+// blockScalarEnd returns the offset right after the last line of the literal or folded
+// scalar whose indicator is at start. Its lines are indented more than parentIndent, the
+// indentation of the collection containing it.
+func (d *yamlDocument) blockScalarEnd(start int, parentIndent int) int {
+	lineEnd := func(pos int) int {
+		if i := bytes.IndexByte(d.content[pos:], '\n'); i >= 0 {
+			return pos + i
+		}
+		return len(d.content)
+	}
+
+	end := lineEnd(start)
+	header := d.content[start:end]
+	if i := commentStart(header); i >= 0 {
+		header = header[:i]
+	}
+	indent, keep := -1, false
+	for _, c := range bytes.TrimSpace(header)[1:] {
+		switch {
+		case c >= '1' && c <= '9':
+			indent = parentIndent + int(c-'0')
+		case c == '+':
+			keep = true
+		}
+	}
+
+	for pos := end + 1; pos < len(d.content); {
+		next := lineEnd(pos)
+		line := bytes.TrimRight(d.content[pos:next], "\r")
+		spaces := len(line) - len(bytes.TrimLeft(line, " "))
+		if len(bytes.TrimSpace(line)) == 0 {
+			// empty lines only belong to the scalar if they are kept
+			if keep {
+				end = next
+			}
+			pos = next + 1
+			continue
+		}
+		if indent < 0 {
+			if spaces <= parentIndent {
+				break
+			}
+			indent = spaces
+		}
+		if spaces < indent {
+			break
+		}
+		end = next
+		pos = next + 1
+	}
+	return end
+}
+
+// flowEnd returns the offset right after the flow mapping or sequence starting at start
+func (d *yamlDocument) flowEnd(start int) (int, error) {
+	depth := 0
+	var quote byte
+	for i := start; i < len(d.content); i++ {
+		c := d.content[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				if quote == '\'' && i+1 < len(d.content) && d.content[i+1] == '\'' {
+					i++
+				} else {
+					quote = 0
+				}
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && i > start && (d.content[i-1] == ' ' || d.content[i-1] == '\t' || d.content[i-1] == '\n'):
+			for i < len(d.content) && d.content[i] != '\n' {
+				i++
+			}
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+			if depth == 0 {
+				return i + 1, nil
+			}
+		}
+	}
+	return 0, errors.New("unterminated flow collection")
+}
+
+// skipProperties returns the offset after the anchor and tag in front of a node at start
+func (d *yamlDocument) skipProperties(start int) int {
+	pos := start
+	for pos < len(d.content) && (d.content[pos] == '&' || d.content[pos] == '!') {
+		for pos < len(d.content) && d.content[pos] != ' ' && d.content[pos] != '\t' && d.content[pos] != '\n' {
+			pos++
+		}
+		for pos < len(d.content) && (d.content[pos] == ' ' || d.content[pos] == '\t') {
+			pos++
+		}
+	}
+	return pos
+}
+
+// nodeEnd returns the offset right after node and its children, parentIndent is the
+// indentation of the collection containing node
+func (d *yamlDocument) nodeEnd(node *yaml.Node, parentIndent int) (int, error) {
+	if (node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode) && node.Style&yaml.FlowStyle == 0 {
+		if len(node.Content) == 0 {
+			return 0, errors.New("failed to locate the end of an empty block collection")
+		}
+		return d.nodeEnd(node.Content[len(node.Content)-1], node.Column-1)
+	}
+
+	start, err := d.offset(node.Line, node.Column)
+	if err != nil {
+		return 0, err
+	}
+	if isNull(node) && node.Value == "" {
+		// implicit null, the position is right after the key
+		return start, nil
+	}
+	start = d.skipProperties(start)
+	switch {
+	case node.Kind == yaml.AliasNode:
+		return start + len("*"+node.Value), nil
+	case node.Kind != yaml.ScalarNode:
+		return d.flowEnd(start)
+	case node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0:
+		return d.blockScalarEnd(start, parentIndent), nil
+	}
+	return d.scalarEnd(node, start)
+}
+
+// formatScalar renders value in the quoting style of the value it replaces.
+// Plain values are only quoted when they would not be read back as a string.
+func formatScalar(value string, style yaml.Style) (string, error) {
+	switch {
+	case style&yaml.SingleQuotedStyle != 0:
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'", nil
+	case style&yaml.DoubleQuotedStyle != 0:
+		style = yaml.DoubleQuotedStyle
+	default:
+		style = 0
+	}
+	out, err := yaml.Marshal(&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Style: style})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// formatValue renders a string in the quoting style of the value it replaces, other values
+// plain so that they keep their type
+func formatValue(value interface{}, style yaml.Style) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return formatScalar(v, style)
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case nil:
+		return "null", nil
+	}
+	return "", fmt.Errorf("value %v of type %T is not a string, number, boolean or null", value, value)
+}
+
+// blockEntry renders keys and value as nested block mappings, one line per key
+func blockEntry(keys []string, value interface{}, indent string) (string, error) {
+	var entry strings.Builder
+	for i, key := range keys {
+		formattedKey, err := formatScalar(key, 0)
+		if err != nil {
+			return "", err
+		}
+		entry.WriteString(indent + strings.Repeat("  ", i) + formattedKey + ":")
+		if i < len(keys)-1 {
+			entry.WriteString("\n")
+		}
+	}
+	formattedValue, err := formatValue(value, 0)
+	if err != nil {
+		return "", err
+	}
+	entry.WriteString(" " + formattedValue + "\n")
+	return entry.String(), nil
+}
+
+// flowEntry renders keys and value as nested flow mappings, e.g. a: {b: value}
+func flowEntry(keys []string, value interface{}) (string, error) {
+	entry, err := formatValue(value, 0)
+	if err != nil {
+		return "", err
+	}
+	for i := len(keys) - 1; i >= 0; i-- {
+		formattedKey, err := formatScalar(keys[i], 0)
+		if err != nil {
+			return "", err
+		}
+		if i < len(keys)-1 {
+			entry = "{" + entry + "}"
+		}
+		entry = formattedKey + ": " + entry
+	}
+	return entry, nil
+}
+
+// lineStart returns the offset of the beginning of line, or the end of the
+// content if the file has less lines. The returned prefix has to be written
+// before any inserted text to terminate the last line.
+func (d *yamlDocument) lineStart(line int) (int, string) {
+	pos, err := d.offset(line, 1)
+	if err == nil {
+		return pos, ""
+	}
+	return d.endOfContent()
+}
+
+// lineAfter returns the offset of the beginning of the line after offset pos, with the
+// prefix of lineStart
+func (d *yamlDocument) lineAfter(pos int) (int, string) {
+	if i := bytes.IndexByte(d.content[pos:], '\n'); i >= 0 {
+		return pos + i + 1, ""
+	}
+	return d.endOfContent()
+}
+
+func (d *yamlDocument) endOfContent() (int, string) {
+	pos := len(d.content)
+	if pos > 0 && d.content[pos-1] != '\n' {
+		return pos, "\n"
+	}
+	return pos, ""
+}
+
+func (d *yamlDocument) insertKey(mapping *yaml.Node, keys []string, value interface{}) error {
+	if mapping.Style&yaml.FlowStyle != 0 {
+		entry, err := flowEntry(keys, value)
+		if err != nil {
+			return err
+		}
+		return d.insertFlowEntry(mapping, entry)
+	}
+
+	insertAt, prefix := len(d.content), ""
+	indent := ""
+	if len(mapping.Content) > 0 {
+		indent = strings.Repeat(" ", mapping.Content[0].Column-1)
+		end, err := d.nodeEnd(mapping, 0)
+		if err != nil {
+			return err
+		}
+		insertAt, prefix = d.lineAfter(end)
+	} else if mapping != d.root {
+		return errors.New("empty block mappings can not be extended")
+	} else if insertAt > 0 && d.content[insertAt-1] != '\n' {
+		prefix = "\n"
+	}
+
+	entry, err := blockEntry(keys, value, indent)
+	if err != nil {
+		return err
+	}
+	d.splice(insertAt, insertAt, prefix+entry)
+	return nil
+}
+
+// replaceNull replaces the null value of keyNode with value, or with nested
+// block mappings if keys are given. Comments in the line of the key are kept.
+func (d *yamlDocument) replaceNull(keyNode *yaml.Node, keys []string, value interface{}) error {
+	keyStart, err := d.offset(keyNode.Line, keyNode.Column)
+	if err != nil {
+		return err
+	}
+	keyEnd, err := d.scalarEnd(keyNode, keyStart)
+	if err != nil {
+		return err
+	}
+
+	lineEnd := keyEnd
+	for lineEnd < len(d.content) && d.content[lineEnd] != '\n' && d.content[lineEnd] != '#' {
+		lineEnd++
+	}
+	for lineEnd > keyEnd && (d.content[lineEnd-1] == ' ' || d.content[lineEnd-1] == '\t' || d.content[lineEnd-1] == '\r') {
+		lineEnd--
+	}
+
+	if len(keys) == 0 {
+		formatted, err := formatValue(value, 0)
+		if err != nil {
+			return err
+		}
+		d.splice(keyEnd, lineEnd, ": "+formatted)
+		return nil
+	}
+
+	indent := strings.Repeat(" ", keyNode.Column-1+2)
+	entry, err := blockEntry(keys, value, indent)
+	if err != nil {
+		return err
+	}
+	insertAt, prefix := d.lineStart(keyNode.Line + 1)
+	d.splice(insertAt, insertAt, prefix+entry)
+	d.splice(keyEnd, lineEnd, ":")
+	return nil
+}
+
+// replaceFlowNull replaces the null value of keyNode in a flow mapping with value, or with
+// nested flow mappings if keys are given
+func (d *yamlDocument) replaceFlowNull(keyNode *yaml.Node, valueNode *yaml.Node, keys []string, value interface{}) error {
+	text, err := formatValue(value, 0)
+	if len(keys) > 0 {
+		text, err = flowEntry(keys, value)
+		text = "{" + text + "}"
+	}
+	if err != nil {
+		return err
+	}
+
+	if valueNode.Value != "" {
+		// explicit null such as ~ or null
+		start, err := d.offset(valueNode.Line, valueNode.Column)
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(d.content[start:], []byte(valueNode.Value)) {
+			return errors.New("values with anchors, aliases or tags can not be edited")
+		}
+		d.splice(start, start+len(valueNode.Value), text)
+		return nil
+	}
+
+	// implicit null, written as 'key:' or only 'key'
+	keyStart, err := d.offset(keyNode.Line, keyNode.Column)
+	if err != nil {
+		return err
+	}
+	keyEnd, err := d.scalarEnd(keyNode, keyStart)
+	if err != nil {
+		return err
+	}
+	pos := keyEnd
+	for pos < len(d.content) && (d.content[pos] == ' ' || d.content[pos] == '\t') {
+		pos++
+	}
+	if pos < len(d.content) && d.content[pos] == ':' {
+		end := pos + 1
+		for end < len(d.content) && (d.content[end] == ' ' || d.content[end] == '\t') {
+			end++
+		}
+		d.splice(pos+1, end, " "+text)
+		return nil
+	}
+	d.splice(keyEnd, keyEnd, ": "+text)
+	return nil
+}
+
+func (d *yamlDocument) insertFlowEntry(mapping *yaml.Node, entry string) error {
+	start, err := d.offset(mapping.Line, mapping.Column)
+	if err != nil {
+		return err
+	}
+	start = d.skipProperties(start)
+	if start >= len(d.content) || d.content[start] != '{' {
+		return errors.New("failed to locate flow mapping")
+	}
+	// nested flow collections are skipped to find the closing brace of this mapping
+	end, err := d.flowEnd(start)
+	if err != nil {
+		return err
+	}
+	if len(mapping.Content) > 0 {
+		entry = ", " + entry
+	}
+	d.splice(end-1, end-1, entry)
+	return nil
+}
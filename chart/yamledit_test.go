@@ -0,0 +1,131 @@
+package chart
+
+import (
+	"strings"
+	"testing"
+)
+
+func editYaml(content string, keys []string, value string) (string, error) {
+	doc := &yamlDocument{path: "values.yaml", content: []byte(content)}
+	if err := doc.parse(); err != nil {
+		return "", err
+	}
+	if err := doc.setString(keys, value); err != nil {
+		return "", err
+	}
+	return string(doc.content), nil
+}
+
+func TestSetString(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		key      string
+		value    string
+		expected string
+	}{
+		// empty documents and missing keys
+		{"empty file", "", "global.image_tag", "0.1.0", "global:\n  image_tag: 0.1.0\n"},
+		{"only a comment", "# values\n", "a", "1", "# values\na: \"1\"\n"},
+		{"no trailing newline", "a: 1", "b", "x", "a: 1\nb: x\n"},
+		{"missing key", "global:\n  a: 1\n", "global.b", "x", "global:\n  a: 1\n  b: x\n"},
+		{"missing parents", "a: 1\n", "global.gpu.max", "x", "a: 1\nglobal:\n  gpu:\n    max: x\n"},
+		{"nested mapping", "global:\n  a:\n    b: 1\nother: 2\n", "global.c", "x", "global:\n  a:\n    b: 1\n  c: x\nother: 2\n"},
+		{"indented by four spaces", "global:\n    a: 1\n", "global.b", "x", "global:\n    a: 1\n    b: x\n"},
+		{"sequence as last value", "global:\n  list:\n  - a\n  - b\nother: 1\n", "global.c", "x", "global:\n  list:\n  - a\n  - b\n  c: x\nother: 1\n"},
+
+		// replacing scalars
+		{"plain", "global:\n  image_tag: 0.0.0\n", "global.image_tag", "0.1.0", "global:\n  image_tag: 0.1.0\n"},
+		{"plain with comment", "tag: old # the tag\n", "tag", "new", "tag: new # the tag\n"},
+		{"double quoted", "tag: \"old\"\n", "tag", "new", "tag: \"new\"\n"},
+		{"double quoted with escapes", "tag: \"o\\\"ld\" # c\n", "tag", "new", "tag: \"new\" # c\n"},
+		{"single quoted", "tag: 'o''ld'\n", "tag", "it's", "tag: 'it''s'\n"},
+		{"plain value that needs quotes", "tag: old\n", "tag", "true", "tag: \"true\"\n"},
+		{"multi-line plain", "desc: first\n  second\ntag: old\n", "tag", "new", "desc: first\n  second\ntag: new\n"},
+
+		// keys inserted after multi-line values
+		{"after literal block scalar", "global:\n  script: |\n    a\n    b\n", "global.tag", "x", "global:\n  script: |\n    a\n    b\n  tag: x\n"},
+		{"after folded block scalar", "global:\n  desc: >\n    a\n    b\nother: 1\n", "global.tag", "x", "global:\n  desc: >\n    a\n    b\n  tag: x\nother: 1\n"},
+		{"after kept block scalar", "global:\n  desc: |+\n    a\n\nother: 1\n", "global.tag", "x", "global:\n  desc: |+\n    a\n\n  tag: x\nother: 1\n"},
+		{"after stripped block scalar", "global:\n  desc: |-\n    a\n\n# comment\nother: 1\n", "global.tag", "x", "global:\n  desc: |-\n    a\n  tag: x\n\n# comment\nother: 1\n"},
+		{"after block scalar with indentation indicator", "global:\n  desc: |2\n      indented\n    a\n", "global.tag", "x", "global:\n  desc: |2\n      indented\n    a\n  tag: x\n"},
+		{"after block scalar with comment in header", "global:\n  desc: | # text\n    a\n", "global.tag", "x", "global:\n  desc: | # text\n    a\n  tag: x\n"},
+		{"after multi-line plain", "global:\n  desc: first\n    second\nother: 1\n", "global.tag", "x", "global:\n  desc: first\n    second\n  tag: x\nother: 1\n"},
+		{"after multi-line plain with empty line", "global:\n  desc: first\n\n    second # c\n", "global.tag", "x", "global:\n  desc: first\n\n    second # c\n  tag: x\n"},
+		{"after multi-line double quoted", "global:\n  desc: \"first\n    second\"\nother: 1\n", "global.tag", "x", "global:\n  desc: \"first\n    second\"\n  tag: x\nother: 1\n"},
+		{"after multi-line single quoted", "global:\n  desc: 'first\n    second'\n", "global.tag", "x", "global:\n  desc: 'first\n    second'\n  tag: x\n"},
+		{"after multi-line flow mapping", "global:\n  map: {a: 1,\n    b: 2}\nother: 1\n", "global.tag", "x", "global:\n  map: {a: 1,\n    b: 2}\n  tag: x\nother: 1\n"},
+		{"after anchored value", "global:\n  a: &anchor 1\n", "global.tag", "x", "global:\n  a: &anchor 1\n  tag: x\n"},
+		{"after alias", "base: &b 1\nglobal:\n  a: *b\n", "global.tag", "x", "base: &b 1\nglobal:\n  a: *b\n  tag: x\n"},
+		{"before trailing comments", "global:\n  a: 1\n  # about b\nother: 1\n", "global.tag", "x", "global:\n  a: 1\n  tag: x\n  # about b\nother: 1\n"},
+
+		// replacing nulls in block mappings
+		{"null", "global:\n", "global", "x", "global: x\n"},
+		{"null with nested keys", "global:\nother: 1\n", "global.tag", "x", "global:\n  tag: x\nother: 1\n"},
+		{"explicit null with comment", "global: ~ # set by the build\n", "global.tag", "x", "global: # set by the build\n  tag: x\n"},
+		{"null as last line without newline", "global: null", "global.tag", "x", "global:\n  tag: x\n"},
+
+		// flow mappings
+		{"empty flow mapping", "global: {}\n", "global.tag", "x", "global: {tag: x}\n"},
+		{"flow mapping", "global: {a: 1}\n", "global.tag", "x", "global: {a: 1, tag: x}\n"},
+		{"nested flow mapping", "global: {a: {b: 1}}\n", "global.a.c", "x", "global: {a: {b: 1, c: x}}\n"},
+		{"missing parents in flow mapping", "global: {a: 1}\n", "global.gpu.max", "x", "global: {a: 1, gpu: {max: x}}\n"},
+		{"flow mapping with quoted braces", "global: {a: \"}\", b: '{'}\n", "global.tag", "x", "global: {a: \"}\", b: '{', tag: x}\n"},
+		{"flow mapping with comment", "global: {a: 1, # c }\n  b: 2}\n", "global.tag", "x", "global: {a: 1, # c }\n  b: 2, tag: x}\n"},
+		{"flow mapping with sequences", "global: {a: [1, {b: 2}], c: [x]}\n", "global.tag", "x", "global: {a: [1, {b: 2}], c: [x], tag: x}\n"},
+		{"flow mapping with escaped quotes", "global: {a: \"\\\"}\", b: 'it''s}'}\n", "global.tag", "x", "global: {a: \"\\\"}\", b: 'it''s}', tag: x}\n"},
+		{"anchored flow mapping", "global: &g {a: 1}\n", "global.tag", "x", "global: &g {a: 1, tag: x}\n"},
+		{"after flow mapping in block mapping", "global:\n  map: {a: 1} # c\n  b: 2\n", "global.map.c", "x", "global:\n  map: {a: 1, c: x} # c\n  b: 2\n"},
+		{"scalar in flow mapping", "global: {tag: old, a: 1}\n", "global.tag", "new", "global: {tag: new, a: 1}\n"},
+		{"explicit null in flow mapping", "x: {a: ~, b: 1}\n", "x.a.c", "v", "x: {a: {c: v}, b: 1}\n"},
+		{"implicit null in flow mapping", "x: {a: , b: 1}\n", "x.a", "v", "x: {a: v, b: 1}\n"},
+		{"key without value in flow mapping", "x: {a, b: 1}\n", "x.a", "v", "x: {a: v, b: 1}\n"},
+
+		// CRLF line endings are kept
+		{"CRLF insert", "global:\r\n  a: 1\r\nother: 2\r\n", "global.tag", "x", "global:\r\n  a: 1\r\n  tag: x\r\nother: 2\r\n"},
+		{"CRLF replace", "tag: old\r\n", "tag", "new", "tag: new\r\n"},
+		{"CRLF null", "global: ~\r\nother: 1\r\n", "global.tag", "x", "global:\r\n  tag: x\r\nother: 1\r\n"},
+		{"CRLF after block scalar", "global:\r\n  desc: |\r\n    a\r\n", "global.tag", "x", "global:\r\n  desc: |\r\n    a\r\n  tag: x\r\n"},
+		{"CRLF after multi-line plain", "global:\r\n  desc: a\r\n    b\r\n", "global.tag", "x", "global:\r\n  desc: a\r\n    b\r\n  tag: x\r\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content, err := editYaml(test.content, strings.Split(test.key, "."), test.value)
+			if err != nil {
+				t.Fatalf("failed to set %s: %v", test.key, err)
+			}
+			if content != test.expected {
+				t.Fatalf("expected\n%q\ngot\n%q", test.expected, content)
+			}
+		})
+	}
+}
+
+func TestSetStringErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		key     string
+	}{
+		{"block scalar", "desc: |\n  a\n", "desc"},
+		{"anchored value", "tag: &t old\n", "tag"},
+		{"tagged value", "tag: !!str old\n", "tag"},
+		{"alias", "base: &b 1\ntag: *b\n", "tag"},
+		{"scalar parent", "global: 1\n", "global.tag"},
+		{"sequence parent", "global: [a]\n", "global.tag"},
+		{"sequence value", "global: [a]\n", "global"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content, err := editYaml(test.content, strings.Split(test.key, "."), "x")
+			if err == nil {
+				t.Fatalf("expected an error, got\n%q", content)
+			}
+		})
+	}
+
+	doc := &yamlDocument{path: "values.yaml", content: []byte("- a\n")}
+	if err := doc.parse(); err == nil {
+		t.Fatalf("expected a sequence at the top level to be rejected")
+	}
+}
@@ -1,125 +1,743 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"extensionctl/bundle"
 	"extensionctl/chart"
 	"extensionctl/extension"
 	"extensionctl/image"
+	"extensionctl/upload"
 	"extensionctl/util"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"helm.sh/helm/v3/pkg/release"
 )
 
 func ImageCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "image [json file]",
-		Short: "Build and save Docker images",
-		Args:  cobra.ExactArgs(1),
-		RunE:  buildImages,
+		Use:               "image [json file]",
+		Short:             "Build and save Docker images",
+		Args:              cobra.ExactArgs(1),
+		RunE:              withWatch(buildImages, true, false),
+		ValidArgsFunction: completeConfigFile,
 	}
+	cmd.Flags().StringSlice("only", nil, "only rebuild these images of the extension or prerequisites from kaapana_path, by the name in LABEL IMAGE")
+	cmd.RegisterFlagCompletionFunc("only", completeImageNames)
 
 	return cmd
 }
 
 func ChartCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "chart [json file]",
-		Short: "Package the Helm chart",
+		Use:               "chart [json file]",
+		Short:             "Package the Helm chart",
+		Args:              cobra.ExactArgs(1),
+		RunE:              withWatch(packageChart, false, true),
+		ValidArgsFunction: completeConfigFile,
+	}
+
+	return cmd
+}
+
+func VerifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "verify [json file]",
+		Short:             "Check that all images referenced by the chart are built and saved",
+		Args:              cobra.ExactArgs(1),
+		RunE:              withConfig(verifyExtension),
+		ValidArgsFunction: completeConfigFile,
+	}
+	cmd.Flags().StringP("output-dir", "o", "", "directory containing the saved images (default <dir_path>/dist)")
+	cmd.Flags().String("public-keyring", "", "verify the signatures of the chart and images with this public keyring (default ~/.gnupg/pubring.gpg)")
+
+	return cmd
+}
+
+func BundleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "bundle [json file]",
+		Short:             "Build the extension and combine the charts and images into a single bundle",
+		Args:              cobra.ExactArgs(1),
+		RunE:              withConfig(createBundle),
+		ValidArgsFunction: completeConfigFile,
+	}
+	cmd.Flags().StringP("output-dir", "o", "", "directory for the packaged chart, saved images, bundle and artifacts.json (default <dir_path>/dist)")
+	addSignFlags(cmd.Flags())
+	cmd.Flags().Duration("timeout", 0, "time limit for each step, e.g. building one image or saving the images, 0 means no limit")
+	cmd.Flags().String("events", "", "write the progress of the build as newline-delimited events to stdout and the logs to stderr, one of "+strings.Join(util.EventFormats, "|"))
+	cmd.RegisterFlagCompletionFunc("events", completeValues(util.EventFormats...))
+
+	cmd.AddCommand(&cobra.Command{
+		Use:               "inspect [bundle]",
+		Short:             "Show the manifest of a bundle",
+		Args:              cobra.ExactArgs(1),
+		RunE:              inspectBundle,
+		ValidArgsFunction: completeBundle,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:               "verify [bundle]",
+		Short:             "Check the checksums of all files in a bundle against its manifest",
+		Args:              cobra.ExactArgs(1),
+		RunE:              verifyBundle,
+		ValidArgsFunction: completeBundle,
+	})
+
+	return cmd
+}
+
+func InstallCmd() *cobra.Command {
+	return releaseCmd("install [chart tgz or name]", "Install an extension with the global values of the platform", false)
+}
+
+func UpgradeCmd() *cobra.Command {
+	return releaseCmd("upgrade [chart tgz or name]", "Upgrade an installed extension", true)
+}
+
+// releaseCmd returns the install command, or the upgrade command if upgrade is set
+func releaseCmd(use string, short string, upgrade bool) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
 		Args:  cobra.ExactArgs(1),
-		RunE:  packageChart,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return installExtension(cmd, args, upgrade)
+		},
+		ValidArgsFunction: completeExtensionChart,
 	}
+	addReleaseFlags(cmd)
+	cmd.Flags().String("version", "", "chart version if the extension is referenced by name (default the highest version in the extensions directory)")
+	cmd.Flags().String("extensions-dir", "", "directory containing the extension charts (default "+extension.DefaultExtensionsDir+")")
+	cmd.RegisterFlagCompletionFunc("extensions-dir", completeDirs)
+	cmd.Flags().StringArray("set", []string{}, "set values on the command line, e.g. --set global.key=value")
 
 	return cmd
 }
 
-func packageChart(cmd *cobra.Command, args []string) error {
-	noSave, _ := cmd.Flags().GetBool("no_save")
-	noRebuild, _ := cmd.Flags().GetBool("no_rebuild")
+func UninstallCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "uninstall [release name]",
+		Short:             "Uninstall an extension",
+		Args:              cobra.ExactArgs(1),
+		RunE:              uninstallExtension,
+		ValidArgsFunction: completeRelease,
+	}
+	addReleaseFlags(cmd)
+
+	return cmd
+}
+
+func PlatformCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "platform",
+		Short: "Inspect the running Kaapana platform",
+	}
+	infoCmd := &cobra.Command{
+		Use:   "info",
+		Short: "Show the version, registry, namespaces and nodes of the platform",
+		Args:  cobra.NoArgs,
+		RunE:  platformInfo,
+	}
+	infoCmd.Flags().StringP("output", "o", "table", "output format, one of table|json|yaml")
+	infoCmd.RegisterFlagCompletionFunc("output", completeValues("table", "json", "yaml"))
+	cmd.AddCommand(infoCmd)
+
+	return cmd
+}
+
+func platformInfo(cmd *cobra.Command, args []string) error {
 	noColor, _ := cmd.Flags().GetBool("no_color")
+	if noColor {
+		os.Setenv("NO_COLOR", "TRUE")
+	}
+	output, _ := cmd.Flags().GetString("output")
+	if output == "json" || output == "yaml" {
+		// keep stdout parseable
+		color.Output = os.Stderr
+	}
+
+	clientset, err := util.KubeClientset()
+	if err != nil {
+		return err
+	}
+	platform, err := util.DiscoverPlatform(cmd.Context(), clientset, util.KaapanaNamespace())
+	if err != nil {
+		return fmt.Errorf("failed to read the platform from the cluster: %w", err)
+	}
+	return platform.Print(os.Stdout, output)
+}
+
+func UploadCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "upload [chart tgz, images tar or bundle]",
+		Short: "Upload an extension to the platform",
+		Long:  "Upload a chart, an image archive or a bundle to the kube-helm backend of the platform and wait until the platform lists the extension. Interrupted uploads of image archives continue where they stopped when run again.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  uploadExtension,
+	}
+	cmd.Flags().String("url", "", "url of the kube-helm api of the platform, e.g. https://kaapana.example.com/kube-helm-api")
+	cmd.MarkFlagRequired("url")
+	cmd.Flags().String("token", "", "bearer token for the platform (default "+upload.TokenEnv+")")
+	cmd.Flags().String("oidc-issuer", "", "request a token from this OpenID Connect issuer, e.g. https://kaapana.example.com/auth/realms/kaapana")
+	cmd.Flags().String("client-id", "kaapana", "OpenID Connect client id")
+	cmd.Flags().String("client-secret-file", "", "file containing the OpenID Connect client secret")
+	cmd.Flags().String("username", "", "username for the password grant, without it the client credentials grant is used")
+	cmd.Flags().String("password-file", "", "file containing the password of --username, '-' to read from stdin")
+	cmd.Flags().Int64("chunk-size", upload.DefaultChunkSize, "size of the chunks image archives are uploaded in, in bytes")
+	cmd.Flags().Bool("insecure-skip-tls-verify", false, "do not verify the certificate of the platform")
+	cmd.Flags().Bool("wait", true, "wait until the platform lists the uploaded charts")
+	cmd.Flags().Duration("timeout", upload.DefaultTimeout, "time to wait until the platform lists the uploaded charts")
+
+	return cmd
+}
+
+func readSecretFile(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	var content []byte
+	var err error
+	if path == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
 
+func uploadExtension(cmd *cobra.Command, args []string) error {
+	noColor, _ := cmd.Flags().GetBool("no_color")
 	if noColor {
 		os.Setenv("NO_COLOR", "TRUE")
 	}
+	url, _ := cmd.Flags().GetString("url")
+	token, _ := cmd.Flags().GetString("token")
+	if token == "" {
+		token = os.Getenv(upload.TokenEnv)
+	}
+	issuer, _ := cmd.Flags().GetString("oidc-issuer")
+	clientID, _ := cmd.Flags().GetString("client-id")
+	clientSecretFile, _ := cmd.Flags().GetString("client-secret-file")
+	username, _ := cmd.Flags().GetString("username")
+	passwordFile, _ := cmd.Flags().GetString("password-file")
+	chunkSize, _ := cmd.Flags().GetInt64("chunk-size")
+	insecure, _ := cmd.Flags().GetBool("insecure-skip-tls-verify")
+	wait, _ := cmd.Flags().GetBool("wait")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
 
-	color.Magenta("Building images...")
+	clientSecret, err := readSecretFile(clientSecretFile)
+	if err != nil {
+		return fmt.Errorf("failed to read the client secret: %w", err)
+	}
+	password, err := readSecretFile(passwordFile)
+	if err != nil {
+		return fmt.Errorf("failed to read the password: %w", err)
+	}
+
+	client, err := upload.NewClient(cmd.Context(), upload.Options{
+		URL:                   url,
+		Token:                 token,
+		OIDC:                  upload.OIDCOptions{Issuer: issuer, ClientID: clientID, ClientSecret: clientSecret, Username: username, Password: password},
+		ChunkSize:             chunkSize,
+		InsecureSkipTLSVerify: insecure,
+		Timeout:               timeout,
+		Progress:              os.Stderr,
+	})
+	if err != nil {
+		return &util.ClusterError{Err: err}
+	}
+	if err := client.Upload(cmd.Context(), args[0], wait); err != nil {
+		return &util.ClusterError{Err: err}
+	}
+	color.Green("Successfully uploaded %s", args[0])
+	return nil
+}
+
+func addReleaseFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("namespace", "n", "default", "namespace of the release")
+	cmd.Flags().String("name", "", "release name (default the chart name)")
+	cmd.Flags().Bool("wait", true, "wait until the resources of the release are ready")
+	cmd.Flags().Duration("timeout", extension.DefaultTimeout, "time to wait for the resources of the release")
+}
+
+func getInstallOptions(cmd *cobra.Command) extension.InstallOptions {
+	namespace, _ := cmd.Flags().GetString("namespace")
+	name, _ := cmd.Flags().GetString("name")
+	chartVersion, _ := cmd.Flags().GetString("version")
+	extensionsDir, _ := cmd.Flags().GetString("extensions-dir")
+	set, _ := cmd.Flags().GetStringArray("set")
+	wait, _ := cmd.Flags().GetBool("wait")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	return extension.InstallOptions{
+		ReleaseName:   name,
+		Namespace:     namespace,
+		Version:       chartVersion,
+		ExtensionsDir: extensionsDir,
+		Set:           set,
+		Wait:          wait,
+		Timeout:       timeout,
+	}
+}
+
+func installExtension(cmd *cobra.Command, args []string, upgrade bool) error {
+	noColor, _ := cmd.Flags().GetBool("no_color")
 	if noColor {
 		os.Setenv("NO_COLOR", "TRUE")
 	}
+	opts := getInstallOptions(cmd)
+
+	ch, err := extension.LoadExtensionChart(args[0], opts.Version, opts.ExtensionsDir)
+	if err != nil {
+		return err
+	}
+
+	// same global values as the kube-helm backend of the platform
+	clientset, err := util.KubeClientset()
+	if err != nil {
+		return err
+	}
+	platform, err := util.DiscoverPlatform(cmd.Context(), clientset, util.KaapanaNamespace())
+	if err != nil {
+		return fmt.Errorf("failed to read the platform from the cluster: %w", err)
+	}
+	global := extension.PlatformGlobalValues(platform.Env)
 
+	helmConfig, err := extension.NewActionConfiguration(opts.Namespace)
+	if err != nil {
+		return err
+	}
+
+	var rel *release.Release
+	if upgrade {
+		rel, err = extension.Upgrade(cmd.Context(), helmConfig, ch, global, opts)
+	} else {
+		rel, err = extension.Install(cmd.Context(), helmConfig, ch, global, opts)
+	}
+	if err != nil {
+		return err
+	}
+	color.Green("release %s in namespace %s is %s, revision %d", rel.Name, rel.Namespace, rel.Info.Status.String(), rel.Version)
+
+	statuses, err := extension.GetKubernetesStatus(cmd.Context(), clientset, rel.Name)
+	if err != nil {
+		color.Yellow(err.Error())
+		return nil
+	}
+	for _, status := range statuses {
+		fmt.Printf("- %s\n", status)
+	}
+	return nil
+}
+
+func uninstallExtension(cmd *cobra.Command, args []string) error {
+	noColor, _ := cmd.Flags().GetBool("no_color")
+	if noColor {
+		os.Setenv("NO_COLOR", "TRUE")
+	}
+	opts := getInstallOptions(cmd)
+
+	helmConfig, err := extension.NewActionConfiguration(opts.Namespace)
+	if err != nil {
+		return err
+	}
+	if err := extension.Uninstall(helmConfig, args[0], opts); err != nil {
+		return err
+	}
+	color.Green("release %s uninstalled", args[0])
+	return nil
+}
+
+func addSignFlags(flags *pflag.FlagSet) {
+	flags.Bool("sign", false, "sign the packaged charts (.prov) and the digest manifest of the saved images with an OpenPGP key")
+	flags.String("key", "", "name of the key to sign with, can be omitted if the keyring contains a single private key")
+	flags.String("keyring", "", "keyring containing the signing key (default ~/.gnupg/secring.gpg)")
+	flags.String("passphrase-file", "", "file containing the passphrase of the signing key, '-' to read from stdin")
+}
+
+// packageChart packages the charts and publishes them with --push and --repo-index
+func packageChart(cmd *cobra.Command, args []string, config *util.ExtensionConfig) error {
+	if err := packageCharts(cmd, args, config); err != nil {
+		return err
+	}
+	return publishCharts(cmd, config)
+}
+
+func packageCharts(cmd *cobra.Command, args []string, config *util.ExtensionConfig) error {
 	color.Magenta("Packaging helm chart...")
 	configPath := args[0]
-	config, err := util.ParseConfigFile(configPath, noSave, noRebuild)
+
+	// chart paths, discovered charts are only kept in memory so that new charts are found on the next build
+	migrate := len(config.ChartPaths) == 0 && config.ChartPath != ""
+	config, err := chart.FindChartPaths(config)
 	if err != nil {
-		color.Red("failed to Parse config file %s", err.Error())
 		return err
 	}
-	color.White("parsed config file")
+	if migrate {
+		if err := util.WriteConfigFile(config, configPath); err != nil {
+			return err
+		}
+	}
+	color.Magenta("ChartPaths are set as %s", config.ChartPaths)
 
-	if err := util.ValidateConfig(config.DirPath, config.KaapanaPath); err != nil {
+	sign, signOptions := getSignOptions(cmd)
+	packagedCharts := map[string]string{}
+	artifacts := []util.Artifact{}
+	for _, chartPath := range config.ChartPaths {
+		chartConfig := config.ForChart(chartPath)
+		color.Magenta("Packaging helm chart %s...", chartPath)
+
+		// requirements
+		err = chart.HandleRequirements(chartConfig)
+		if err != nil {
+			return fmt.Errorf("failed to update requirements: %w", err)
+		}
+		color.Magenta("Succesfully updated chart requirements")
+
+		// Chart.yaml
+		err = chart.EditChartYaml(chartConfig)
+		if err != nil {
+			return fmt.Errorf("failed to update Chart.yaml: %w", err)
+		}
+		color.Magenta("Succesfully updated Chart.yaml")
+
+		// values.yaml
+		err = chart.EditValuesYaml(chartConfig)
+		if err != nil {
+			return fmt.Errorf("failed to update values.yaml: %w", err)
+		}
+		color.Magenta("Succesfully updated values.yaml")
+
+		// package
+		packaged, err := chart.PackageChart(chartConfig)
+		if err != nil {
+			return err
+		}
+		if other, ok := packagedCharts[packaged]; ok {
+			return errors.New("charts " + other + " and " + chartPath + " are both packaged as " + packaged + ", change chart_artifact_template")
+		}
+		packagedCharts[packaged] = chartPath
+
+		color.Magenta("Successfully packaged Helm chart %s", packaged)
+
+		artifact, err := util.NewArtifact("chart", packaged)
+		if err != nil {
+			return err
+		}
+		artifacts = append(artifacts, artifact)
+
+		if sign {
+			signer, err := util.LoadSigner(signOptions)
+			if err != nil {
+				return err
+			}
+			provPath, err := chart.SignChart(packaged, signer)
+			if err != nil {
+				return err
+			}
+			artifact, err := util.NewArtifact("provenance", provPath)
+			if err != nil {
+				return err
+			}
+			artifacts = append(artifacts, artifact)
+		}
+	}
+
+	artifacts, err = util.RecordArtifacts(config.OutputPath, artifacts...)
+	if err != nil {
 		return err
 	}
-	color.White("config validated")
+	util.PrintArtifacts(artifacts, config.OutputPath)
 
-	// chart path
-	config, err = chart.FindChartPath(config)
+	return nil
+}
+
+// publishCharts pushes the packaged charts with --push and adds them to the chart repository
+// index with --repo-index. It runs after the charts were packaged and, in a full build, verified.
+func publishCharts(cmd *cobra.Command, config *util.ExtensionConfig) error {
+	pushRemote, _ := cmd.Flags().GetString("push")
+	plainHTTP, _ := cmd.Flags().GetBool("plain-http")
+	repoIndexDir, _ := cmd.Flags().GetString("repo-index")
+	repoURL, _ := cmd.Flags().GetString("repo-url")
+	if pushRemote == "" && repoIndexDir == "" {
+		return nil
+	}
+
+	artifacts := []util.Artifact{}
+	for _, chartPath := range config.ChartPaths {
+		packaged, err := chart.ChartArtifactPath(config.ForChart(chartPath))
+		if err != nil {
+			return err
+		}
+
+		if pushRemote != "" {
+			ref, err := chart.PushChart(packaged, pushRemote, plainHTTP)
+			if err != nil {
+				return err
+			}
+			color.Magenta("Successfully pushed Helm chart to %s", ref)
+		}
+
+		if repoIndexDir != "" {
+			indexPath, err := chart.UpdateRepoIndex(packaged, repoIndexDir, repoURL)
+			if err != nil {
+				return fmt.Errorf("failed to update chart repository index: %w", err)
+			}
+			artifact, err := util.NewArtifact("index", indexPath)
+			if err != nil {
+				return err
+			}
+			artifacts = append(artifacts, artifact)
+		}
+	}
+	if len(artifacts) == 0 {
+		return nil
+	}
+
+	artifacts, err := util.RecordArtifacts(config.OutputPath, artifacts...)
+	if err != nil {
+		return err
+	}
+	util.PrintArtifacts(artifacts, config.OutputPath)
+	return nil
+}
+
+func buildAll(cmd *cobra.Command, args []string, config *util.ExtensionConfig) error {
+	color.Green("Building images and packaging charts")
+	report := util.NewBuildReport()
+	stop := util.OnEvent(report.Record)
+	defer stop()
+	err := buildImages(cmd, args, config)
+	if err != nil {
+		return err
+	}
+	err = packageCharts(cmd, args, config)
+	if err != nil {
+		return err
+	}
+	err = verifyExtension(cmd, args, config)
+	if err != nil {
+		return err
+	}
+	// only verified charts are published
+	err = publishCharts(cmd, config)
+	if err != nil {
+		return err
+	}
+	stop()
+	return writeBuildReport(config, report)
+}
+
+// writeBuildReport prints the report of a finished build and writes it as json and markdown
+// into the output dir
+func writeBuildReport(config *util.ExtensionConfig, report *util.BuildReport) error {
+	artifacts, err := util.RecordArtifacts(config.OutputPath)
+	if err != nil {
+		return err
+	}
+	report.Finish(config, artifacts)
+
+	fmt.Fprintln(color.Output)
+	if err := report.Print(color.Output); err != nil {
+		return err
+	}
+	jsonPath, markdownPath, err := report.Write(config.OutputPath)
 	if err != nil {
-		color.Red("failed to find the chart folder containing Chart.yaml under %s", config.DirPath)
 		return err
 	}
-	err = util.WriteConfigFile(config, configPath)
+	color.Green("Build report written to %s and %s", jsonPath, markdownPath)
+	return nil
+}
+
+func createBundle(cmd *cobra.Command, args []string, config *util.ExtensionConfig) error {
+	if config.NoSave {
+		return errors.New("a bundle contains the saved images, it can not be created with --no_save")
+	}
+
+	if err := buildAll(cmd, args, config); err != nil {
+		return err
+	}
+
+	color.Magenta("Creating bundle...")
+	config, err := chart.FindChartPaths(config)
 	if err != nil {
 		return err
 	}
-	color.Magenta("ChartPath is set as %s", config.ChartPath)
 
-	// requirements
-	err = chart.HandleRequirements(config)
+	artifacts, err := bundle.Collect(config)
+	if err != nil {
+		return err
+	}
+	bundlePath, err := util.BundleArtifactPath(config)
 	if err != nil {
-		color.Red("failed to update requirements %s", err.Error())
 		return err
 	}
-	color.Magenta("Succesfully updated chart requirements")
+	if _, err := bundle.Create(config, artifacts, bundlePath); err != nil {
+		return fmt.Errorf("failed to create bundle %s: %w", bundlePath, err)
+	}
+	color.Magenta("Successfully created bundle %s", bundlePath)
 
-	// Chart.yaml
-	chart.EditChartYaml(config)
+	artifact, err := util.NewArtifact("bundle", bundlePath)
 	if err != nil {
-		color.Red("failed to update Chart.yaml %s", err.Error())
 		return err
 	}
-	color.Magenta("Succesfully updated Chart.yaml")
+	recorded, err := util.RecordArtifacts(config.OutputPath, artifact)
+	if err != nil {
+		return err
+	}
+	util.PrintArtifacts(recorded, config.OutputPath)
+	return nil
+}
 
-	// values.yaml
-	err = chart.EditValuesYaml(config)
+func inspectBundle(cmd *cobra.Command, args []string) error {
+	noColor, _ := cmd.Flags().GetBool("no_color")
+	if noColor {
+		os.Setenv("NO_COLOR", "TRUE")
+	}
+	manifest, err := bundle.Inspect(args[0])
 	if err != nil {
-		color.Red("failed to update values.yaml %s", err.Error())
 		return err
 	}
-	color.Magenta("Succesfully updated values.yaml")
+	manifest.Print()
+	return nil
+}
 
-	// package
-	err = chart.PackageChart(config)
+func verifyBundle(cmd *cobra.Command, args []string) error {
+	noColor, _ := cmd.Flags().GetBool("no_color")
+	if noColor {
+		os.Setenv("NO_COLOR", "TRUE")
+	}
+	color.Magenta("Verifying bundle %s...", args[0])
+	manifest, err := bundle.Verify(args[0])
 	if err != nil {
-		color.Red("failed to package chart %s", err.Error())
 		return err
 	}
+	color.Green("Successfully verified %d files of bundle %s %s", len(manifest.Files), manifest.Name, manifest.ExtensionVersion)
+	return nil
+}
 
-	color.Magenta("Successfully packaged Helm chart")
+// verifyKeyring returns the keyring to verify signatures with and whether they should be verified.
+// On build, --keyring is the signing keyring and only used if the artifacts were signed.
+func verifyKeyring(cmd *cobra.Command) (string, bool) {
+	if sign, signOptions := getSignOptions(cmd); sign {
+		// signed during this build, the secret keyring contains the public keys as well
+		if signOptions.Keyring == "" {
+			return util.DefaultSigningKeyring(), true
+		}
+		return signOptions.Keyring, true
+	}
+	keyring, _ := cmd.Flags().GetString("public-keyring")
+	return keyring, cmd.Flags().Changed("public-keyring")
+}
+
+func getSignOptions(cmd *cobra.Command) (bool, util.SignOptions) {
+	sign, _ := cmd.Flags().GetBool("sign")
+	key, _ := cmd.Flags().GetString("key")
+	keyring, _ := cmd.Flags().GetString("keyring")
+	passphraseFile, _ := cmd.Flags().GetString("passphrase-file")
+	return sign, util.SignOptions{Key: key, Keyring: keyring, PassphraseFile: passphraseFile}
+}
 
+// verifySignatures checks the provenance files of the packaged charts and the signed digest manifest of the saved images
+func verifySignatures(config *util.ExtensionConfig, tarPath string, checkImages bool, keyring string) error {
+	if keyring == "" {
+		keyring = util.DefaultVerifyKeyring()
+	}
+	color.Magenta("Verifying signatures with keyring %s...", keyring)
+
+	for _, chartPath := range config.ChartPaths {
+		packaged, err := chart.ChartArtifactPath(config.ForChart(chartPath))
+		if err != nil {
+			return err
+		}
+		verification, err := chart.VerifyChart(packaged, keyring)
+		if err != nil {
+			return err
+		}
+		for name := range verification.SignedBy.Identities {
+			color.Green("chart %s is signed by %s", packaged, name)
+		}
+	}
+
+	if checkImages {
+		signer, err := image.VerifyImages(tarPath, keyring)
+		if err != nil {
+			return err
+		}
+		color.Green("images %s are signed by %s", tarPath, signer)
+	}
 	return nil
 }
 
-func buildAll(cmd *cobra.Command, args []string) error {
-	color.Green("Building images and packaging charts")
-	err := buildImages(cmd, args)
+func verifyExtension(cmd *cobra.Command, args []string, config *util.ExtensionConfig) error {
+	color.Magenta("Verifying images of the chart...")
+	if len(config.DockerfilePaths) == 0 {
+		if err := image.GlobDockerfilePaths(config, args[0]); err != nil {
+			return err
+		}
+	}
+	config, err := chart.FindChartPaths(config)
+	if err != nil {
+		return err
+	}
+
+	referenced := []string{}
+	for _, chartPath := range config.ChartPaths {
+		references, err := chart.RenderImages(config.ForChart(chartPath))
+		if err != nil {
+			return err
+		}
+		for _, ref := range references {
+			color.Blue("%s %s in %s uses image %s", ref.Kind, ref.Name, ref.Template, ref.Image)
+			referenced = append(referenced, ref.Image)
+		}
+	}
+
+	built, err := image.ImageTags(config)
 	if err != nil {
 		return err
 	}
-	err = packageChart(cmd, args)
+
+	tarPath, err := util.ImagesArtifactPath(config)
 	if err != nil {
 		return err
 	}
+
+	var archived []string
+	if _, err := os.Stat(tarPath); err == nil {
+		archived, err = image.ArchivedImages(tarPath)
+		if err != nil {
+			return fmt.Errorf("failed to read images from %s: %w", tarPath, err)
+		}
+	} else {
+		color.Yellow("%s does not exist, skipping the check of saved images", tarPath)
+	}
+
+	platformImages := append(append([]string{}, image.DefaultPlatformImages...), config.PlatformImages...)
+	report := image.CrossCheckImages(referenced, built, archived, config.CustomRegistryUrl, platformImages)
+	report.Print()
+	if report.Failed() {
+		return &chart.ChartError{Err: errors.New("verification failed, see the images listed above")}
+	}
+
+	if keyring, checkSignatures := verifyKeyring(cmd); checkSignatures {
+		if err := verifySignatures(config, tarPath, archived != nil, keyring); err != nil {
+			return err
+		}
+	}
+
+	color.Magenta("Successfully verified chart images")
 	return nil
 }
 
@@ -128,28 +746,38 @@ func getExtensions(cmd *cobra.Command, args []string) error {
 	if noColor {
 		os.Setenv("NO_COLOR", "TRUE")
 	}
-	extensions, err := extension.GetExtensions()
-	if err != nil {
-		fmt.Println("Error:", err)
+	output, _ := cmd.Flags().GetString("output")
+	if err := extension.PrintExtensions(io.Discard, nil, output); err != nil {
 		return err
 	}
+	if output == "json" || output == "yaml" {
+		// keep stdout parseable
+		color.Output = os.Stderr
+	}
 
-	// Print the extensions
-	for _, ext := range extensions {
-		fmt.Printf("Name: %s\n", ext.Name)
-		fmt.Printf("Version: %s\n", ext.Version)
-		fmt.Printf("Description: %s\n", ext.Description)
-		fmt.Printf("Helm Status: %s\n", ext.HelmStatus)
-		fmt.Printf("Kubernetes Status:\n")
-		for _, status := range ext.KubernetesStatus {
-			fmt.Printf("- %s\n", status)
+	extensionsDir, _ := cmd.Flags().GetString("extensions-dir")
+	if extensionsDir == "" && len(args) == 1 {
+		config, err := util.ReadConfigFile(args[0])
+		if err != nil {
+			return err
 		}
-		fmt.Println("--------")
+		extensionsDir = config.ExtensionsDir
 	}
-	return nil
+
+	extensions, err := extension.GetExtensions(cmd.Context(), extensionsDir)
+	if err != nil {
+		return err
+	}
+	return extension.PrintExtensions(os.Stdout, extensions, output)
 }
 
-func buildImages(cmd *cobra.Command, args []string) error {
+// buildStep is a step of the build that gets the config resolved once for the whole invocation
+type buildStep func(cmd *cobra.Command, args []string, config *util.ExtensionConfig) error
+
+// loadConfig parses and validates the config file and resolves the versions and the output
+// directory. It is called once per invocation, since the build itself edits files in dir_path
+// and a version derived with 'git describe' and git_dirty_version would change between the steps.
+func loadConfig(cmd *cobra.Command, configPath string) (*util.ExtensionConfig, error) {
 	noColor, _ := cmd.Flags().GetBool("no_color")
 	noSave, _ := cmd.Flags().GetBool("no_save")
 	noRebuild, _ := cmd.Flags().GetBool("no_rebuild")
@@ -158,36 +786,211 @@ func buildImages(cmd *cobra.Command, args []string) error {
 		os.Setenv("NO_COLOR", "TRUE")
 	}
 
-	color.Magenta("Building images...")
-	configPath := args[0]
-	config, err := util.ParseConfigFile(configPath, noSave, noRebuild)
+	config, err := util.ParseConfigFile(cmd.Context(), configPath, noSave, noRebuild)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := util.ValidateConfig(config.DirPath, config.KaapanaPath); err != nil {
-		return err
+		return nil, err
 	}
 	color.Blue("config validated")
 
+	outputDir, _ := cmd.Flags().GetString("output-dir")
+	if err := util.ResolveOutputDir(config, outputDir); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// withConfig runs step with the config file given as the first argument
+func withConfig(step buildStep) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		config, err := loadConfig(cmd, args[0])
+		if err != nil {
+			return err
+		}
+		return step(cmd, args, config)
+	}
+}
+
+// withWatch runs the build, with --watch it keeps watching dir_path and rebuilds the images
+// and repackages the charts affected by each change
+func withWatch(build buildStep, images bool, charts bool) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		config, err := loadConfig(cmd, args[0])
+		if err != nil {
+			return err
+		}
+		watch, _ := cmd.Flags().GetBool("watch")
+		if err := build(cmd, args, config); err != nil {
+			if !watch || cmd.Context().Err() != nil {
+				return err
+			}
+			color.Red("build failed, fix the error to rebuild: %s", err.Error())
+		}
+		if !watch {
+			return nil
+		}
+		return watchExtension(cmd, args, config, images, charts)
+	}
+}
+
+func watchExtension(cmd *cobra.Command, args []string, config *util.ExtensionConfig, images bool, charts bool) error {
+	debounce, _ := cmd.Flags().GetDuration("debounce")
+
+	// packaged dependencies are written into the charts folder on every build
+	ignore := []string{config.OutputPath}
+	chartPaths := []string{}
+	if found, err := chart.FindChartPaths(config); err == nil {
+		chartPaths = found.ChartPaths
+	} else if charts {
+		color.Yellow("not watching the charts: %s", err.Error())
+	}
+	for i, chartPath := range chartPaths {
+		abs, err := filepath.Abs(chartPath)
+		if err != nil {
+			return err
+		}
+		chartPaths[i] = abs
+		ignore = append(ignore, filepath.Join(abs, "charts"))
+	}
+	watcher, err := util.NewWatcher(config.DirPath, ignore, debounce)
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %w", config.DirPath, err)
+	}
+	defer watcher.Close()
+
+	for {
+		color.Magenta("Watching %s for changes...", config.DirPath)
+		changed, err := watcher.Next(cmd.Context())
+		if err != nil {
+			if cmd.Context().Err() != nil {
+				// stopped with Ctrl-C
+				return nil
+			}
+			return err
+		}
+		for _, path := range changed {
+			color.Blue("changed: %s", path)
+		}
+
+		if images {
+			dockerfiles, err := image.AffectedDockerfiles(config.DockerfilePaths, changed)
+			if err != nil {
+				color.Red(err.Error())
+			} else if len(dockerfiles) > 0 {
+				if err := buildSelectedImages(cmd, args, config, dockerfiles); err != nil {
+					color.Red("failed to rebuild the images: %s", err.Error())
+				}
+			}
+		}
+		if charts {
+			for _, path := range changed {
+				if containsDir(chartPaths, path) {
+					if err := packageChart(cmd, args, config); err != nil {
+						color.Red("failed to package the charts: %s", err.Error())
+					}
+					break
+				}
+			}
+		}
+	}
+}
+
+func containsDir(dirs []string, path string) bool {
+	for _, dir := range dirs {
+		if util.IsInDir(path, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func buildImages(cmd *cobra.Command, args []string, config *util.ExtensionConfig) error {
+	names, _ := cmd.Flags().GetStringSlice("only")
+	if len(names) == 0 {
+		return buildSelectedImages(cmd, args, config, nil)
+	}
+
+	if len(config.DockerfilePaths) == 0 {
+		if err := image.GlobDockerfilePaths(config, args[0]); err != nil {
+			return err
+		}
+	}
+	prereqDockerfiles, err := image.FindPrereqDockerfiles(config)
+	if err != nil {
+		return err
+	}
+	byImage, err := image.DockerfilesByImage(append(prereqDockerfiles, config.DockerfilePaths...))
+	if err != nil {
+		return err
+	}
+	only := []string{}
+	for _, name := range names {
+		dockerfile, ok := byImage[name]
+		if !ok {
+			return &util.ConfigError{Err: errors.New("--only: " + name + " is neither an image of the extension nor one of its prerequisites")}
+		}
+		only = append(only, dockerfile)
+	}
+	return buildSelectedImages(cmd, args, config, only)
+}
+
+// selectedDockerfiles returns the Dockerfiles that are in only, keeping the order of dockerfiles
+func selectedDockerfiles(dockerfiles []string, only []string) []string {
+	selected := []string{}
+	for _, dockerfile := range dockerfiles {
+		for _, path := range only {
+			if path == dockerfile {
+				selected = append(selected, dockerfile)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// buildSelectedImages builds the given Dockerfiles of the extension and its prerequisites, or all
+// Dockerfiles of the extension if nil. The saved archive always contains all images.
+func buildSelectedImages(cmd *cobra.Command, args []string, config *util.ExtensionConfig, only []string) error {
+	loadInto, _ := cmd.Flags().GetString("load-into")
+	if loadInto != "" {
+		if err := image.ValidateLoadTarget(loadInto); err != nil {
+			return err
+		}
+		if config.NoSave {
+			return errors.New("--load-into imports the saved images, it can not be used with --no_save")
+		}
+	}
+
+	color.Magenta("Building images...")
+	configPath := args[0]
 	if len(config.DockerfilePaths) == 0 {
 		if err := image.GlobDockerfilePaths(config, configPath); err != nil {
 			return err
 		}
 	}
-	fmt.Printf("config.DockerfilePaths: %s\n", config.DockerfilePaths)
+	fmt.Fprintf(color.Output, "config.DockerfilePaths: %s\n", config.DockerfilePaths)
 
 	prereqDockerfiles, err := image.FindPrereqDockerfiles(config)
 	if err != nil {
 		return err
 	}
 
-	// change image references
+	// change image references, the .py files are restored if the build is interrupted at any
+	// point after they were changed
+	changes := image.NewFileChanges()
+	defer func() {
+		if cmd.Context().Err() != nil {
+			changes.Restore()
+		}
+	}()
 	if !config.NoOverwriteOperators {
-		if err := image.ChangeImageRefs(config.DirPath, "{DEFAULT_REGISTRY}", config.CustomRegistryUrl); err != nil {
+		if err := image.ChangeImageRefs(cmd.Context(), changes, config.DirPath, "{DEFAULT_REGISTRY}", config.CustomRegistryUrl); err != nil {
 			return err
 		}
-		if err := image.ChangeImageRefs(config.DirPath, "{KAAPANA_BUILD_VERSION}\",", config.KaapanaBuildVersion+"\",\nimage_pull_policy=\"IfNotPresent\",\n"); err != nil {
+		if err := image.ChangeImageRefs(cmd.Context(), changes, config.DirPath, "{KAAPANA_BUILD_VERSION}\",", config.Versions.Image+"\",\nimage_pull_policy=\"IfNotPresent\",\n"); err != nil {
 			return err
 		}
 	} else {
@@ -200,31 +1003,136 @@ func buildImages(cmd *cobra.Command, args []string) error {
 	}
 	color.Blue("prioritized prereqDockerfiles %s", prereqDockerfiles)
 
+	dockerfiles := config.DockerfilePaths
+	if only != nil {
+		// the selected images are rebuilt because they changed, prerequisites only if they were selected
+		prereqDockerfiles = selectedDockerfiles(prereqDockerfiles, only)
+		dockerfiles = selectedDockerfiles(dockerfiles, only)
+		rebuild := *config
+		rebuild.NoRebuild = false
+		config = &rebuild
+	}
+
 	for _, prereqDockerfile := range prereqDockerfiles {
-		if _, err := image.BuildDockerImage(prereqDockerfile, config, true); err != nil {
+		ctx, cancel := stepContext(cmd)
+		_, err := image.BuildDockerImage(ctx, prereqDockerfile, config, true)
+		cancel()
+		if err != nil {
 			return err
 		}
 	}
 
 	imageTags := []string{}
-	for _, dockerfile := range config.DockerfilePaths {
-		imageTag, err := image.BuildDockerImage(dockerfile, config, false)
+	for _, dockerfile := range dockerfiles {
+		ctx, cancel := stepContext(cmd)
+		imageTag, err := image.BuildDockerImage(ctx, dockerfile, config, false)
+		cancel()
 		if err != nil {
-			color.Red("Failed to build image: %s , err: %s", imageTags, err.Error())
 			return err
 		}
 		imageTags = append(imageTags, imageTag)
 	}
-	err = image.SaveImages(imageTags, config.DirPath, config.ContainerEngine)
+	if only != nil {
+		imageTags, err = image.ImageTags(config)
+		if err != nil {
+			return err
+		}
+	}
+	if config.NoSave {
+		color.Yellow("not saving images since no_save==true")
+		color.Blue("Successfully built the images.")
+		return nil
+	}
+
+	savePath, err := util.ImagesArtifactPath(config)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := stepContext(cmd)
+	err = image.SaveImages(ctx, imageTags, savePath, config.ContainerEngine)
+	cancel()
 	if err != nil {
-		color.Red("Failed to save images: %s , err: %s", imageTags, err.Error())
 		return err
 	}
 
 	color.Blue("Successfully built and saved the images.")
+
+	artifact, err := util.NewArtifact("images", savePath)
+	if err != nil {
+		return err
+	}
+	artifacts := []util.Artifact{artifact}
+
+	if sign, signOptions := getSignOptions(cmd); sign {
+		signer, err := util.LoadSigner(signOptions)
+		if err != nil {
+			return err
+		}
+		manifestPath, sigPath, err := image.SignImages(savePath, signer)
+		if err != nil {
+			return err
+		}
+		manifestArtifact, err := util.NewArtifact("digests", manifestPath)
+		if err != nil {
+			return err
+		}
+		sigArtifact, err := util.NewArtifact("signature", sigPath)
+		if err != nil {
+			return err
+		}
+		artifacts = append(artifacts, manifestArtifact, sigArtifact)
+	}
+
+	artifacts, err = util.RecordArtifacts(config.OutputPath, artifacts...)
+	if err != nil {
+		return err
+	}
+	util.PrintArtifacts(artifacts, config.OutputPath)
+
+	if loadInto != "" {
+		kindCluster, _ := cmd.Flags().GetString("kind-cluster")
+		ctx, cancel := stepContext(cmd)
+		err := image.LoadImages(ctx, savePath, imageTags, loadInto, kindCluster)
+		cancel()
+		if err != nil {
+			return err
+		}
+		color.Magenta("Successfully imported the images into %s", loadInto)
+	}
 	return nil
 }
 
+// setEvents writes the --events stream to stdout, the logs and the output of the container
+// engine go to stderr then
+func setEvents(cmd *cobra.Command) error {
+	format, _ := cmd.Flags().GetString("events")
+	switch format {
+	case "":
+		return nil
+	case "json":
+		color.Output = os.Stderr
+		util.SetEventOutput(os.Stdout)
+		return nil
+	}
+	return &usageError{err: fmt.Errorf("unknown events format '%s', use one of %s", format, strings.Join(util.EventFormats, "|"))}
+}
+
+// stepContext limits a single step of the build, e.g. building one image, to --timeout
+func stepContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	if timeout > 0 {
+		return context.WithTimeout(cmd.Context(), timeout)
+	}
+	return context.WithCancel(cmd.Context())
+}
+
+func setKubeOptions(cmd *cobra.Command) {
+	kubeconfig, _ := cmd.Flags().GetString("kubeconfig")
+	kubeContext, _ := cmd.Flags().GetString("context")
+	kaapanaNamespace, _ := cmd.Flags().GetString("kaapana-namespace")
+	util.SetKubeOptions(util.KubeOptions{Kubeconfig: kubeconfig, Context: kubeContext, KaapanaNamespace: kaapanaNamespace})
+}
+
 func main() {
 	rootCmd := &cobra.Command{
 		Use:   "extensionctl",
@@ -234,20 +1142,33 @@ func main() {
 			// Display help information if no command is specified
 			cmd.Help()
 		},
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			setKubeOptions(cmd)
+			return setEvents(cmd)
+		},
+		Version: version,
 	}
+	rootCmd.SetVersionTemplate(versionText())
 
 	extensionsCmd := &cobra.Command{
-		Use:   "extensions",
-		Short: "Get extensions",
-		Long:  "Get all extensions",
-		RunE:  getExtensions,
+		Use:               "extensions [json file]",
+		Short:             "Get extensions",
+		Long:              "Get all extensions, the extensions directory is read from extensions_dir of the optional config file",
+		Args:              cobra.MaximumNArgs(1),
+		RunE:              getExtensions,
+		ValidArgsFunction: completeConfigFile,
 	}
+	extensionsCmd.Flags().String("extensions-dir", "", "directory containing the extension charts (default "+extension.DefaultExtensionsDir+")")
+	extensionsCmd.Flags().StringP("output", "o", "table", "output format, one of "+strings.Join(extension.OutputFormats, "|"))
+	extensionsCmd.RegisterFlagCompletionFunc("output", completeValues(extension.OutputFormats...))
+	extensionsCmd.RegisterFlagCompletionFunc("extensions-dir", completeDirs)
 
 	buildCmd := &cobra.Command{
-		Use:   "build",
-		Short: "generate chart tgz, build and save Docker images",
-		Args:  cobra.ExactArgs(1),
-		RunE:  buildAll,
+		Use:               "build",
+		Short:             "generate chart tgz, build and save Docker images",
+		Args:              cobra.ExactArgs(1),
+		RunE:              withWatch(buildAll, true, true),
+		ValidArgsFunction: completeConfigFile,
 	}
 
 	// Flags
@@ -255,17 +1176,71 @@ func main() {
 	rootCmd.PersistentFlags().BoolP("no_save", "s", false, "disable saving images as .tar files")
 	rootCmd.PersistentFlags().BoolP("no_rebuild", "b", false, "disable rebuilding existing images")
 	rootCmd.PersistentFlags().BoolP("no_overwrite_operators", "w", false, "disable searching and replacing patterns in py files")
+	rootCmd.PersistentFlags().String("kubeconfig", "", "path to the kubeconfig file (default KUBECONFIG or ~/.kube/config, in-cluster config if there is none)")
+	rootCmd.PersistentFlags().String("context", "", "kubeconfig context to use (default the current context)")
+	rootCmd.PersistentFlags().String("kaapana-namespace", util.DefaultKaapanaNamespace, "namespace of the Kaapana platform services such as kube-helm")
+
+	buildCmd.PersistentFlags().StringP("output-dir", "o", "", "directory for the packaged chart, saved images and artifacts.json (default <dir_path>/dist)")
+
+	addSignFlags(buildCmd.PersistentFlags())
+
+	buildCmd.PersistentFlags().String("load-into", "", "import the saved images into the containerd of a local cluster, one of "+strings.Join(image.LoadTargets, "|"))
+	buildCmd.PersistentFlags().String("kind-cluster", "kind", "name of the kind cluster for --load-into kind")
+	buildCmd.RegisterFlagCompletionFunc("load-into", completeValues(image.LoadTargets...))
+	buildCmd.RegisterFlagCompletionFunc("output-dir", completeDirs)
+
+	buildCmd.PersistentFlags().Duration("timeout", 0, "time limit for each step, e.g. building one image or saving the images, 0 means no limit")
+
+	buildCmd.PersistentFlags().String("events", "", "write the progress of the build as newline-delimited events to stdout and the logs to stderr, one of "+strings.Join(util.EventFormats, "|"))
+	buildCmd.RegisterFlagCompletionFunc("events", completeValues(util.EventFormats...))
+
+	buildCmd.PersistentFlags().Bool("watch", false, "keep watching dir_path and rebuild the images and charts affected by each change")
+	buildCmd.PersistentFlags().Duration("debounce", util.DefaultDebounce, "time without further changes before --watch rebuilds")
+
+	buildCmd.PersistentFlags().String("push", "", "push the packaged charts to an OCI registry, e.g. oci://registry/project")
+	buildCmd.PersistentFlags().Bool("plain-http", false, "use insecure HTTP connections for --push")
+	buildCmd.PersistentFlags().String("repo-index", "", "copy the packaged charts into this directory and add them to its index.yaml chart repository index")
+	buildCmd.PersistentFlags().String("repo-url", "", "url of the chart repository, prefixed to the chart urls in index.yaml")
 
 	// Add subcommands for different functionalities
 	rootCmd.AddCommand(extensionsCmd)
 	rootCmd.AddCommand(buildCmd)
 	buildCmd.AddCommand(ImageCmd())
 	buildCmd.AddCommand(ChartCmd())
+	rootCmd.AddCommand(VerifyCmd())
+	rootCmd.AddCommand(BundleCmd())
+	rootCmd.AddCommand(InstallCmd())
+	rootCmd.AddCommand(UpgradeCmd())
+	rootCmd.AddCommand(UninstallCmd())
+	rootCmd.AddCommand(PlatformCmd())
+	rootCmd.AddCommand(UploadCmd())
+	rootCmd.AddCommand(VersionCmd())
 
-	// Execute the CLI
-	if err := rootCmd.Execute(); err != nil {
+	// errors are printed once below, usage only on request
+	rootCmd.SilenceErrors = true
+	rootCmd.SilenceUsage = true
+	validateUsage(rootCmd)
+
+	// the first SIGINT or SIGTERM cancels the context, running engine commands are interrupted
+	// and unfinished file edits restored, a second one exits immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		color.New(color.FgYellow).Fprintln(os.Stderr, "interrupted, cleaning up...")
+	}()
 
-		fmt.Println(err)
-		os.Exit(1)
+	// Execute the CLI
+	if cmd, err := rootCmd.ExecuteContextC(ctx); err != nil {
+		color.New(color.FgRed).Fprintln(os.Stderr, "Error: "+err.Error())
+		code := exitCode(err)
+		if ctx.Err() != nil {
+			code = exitInterrupted
+		}
+		util.Emit(util.Event{Type: util.EventError, Message: err.Error(), ExitCode: code})
+		if code == exitUsage {
+			fmt.Fprintf(os.Stderr, "Run '%s --help' for usage.\n", cmd.CommandPath())
+		}
+		os.Exit(code)
 	}
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"extensionctl/util"
+)
+
+func TestVerifyKeyring(t *testing.T) {
+	cases := []struct {
+		name            string
+		newCmd          func() *cobra.Command
+		args            []string
+		expectedKeyring string
+		expectedCheck   bool
+	}{
+		{"verify without keyring", VerifyCmd, nil, "", false},
+		{"verify with public keyring", VerifyCmd, []string{"--public-keyring", "pub.gpg"}, "pub.gpg", true},
+		{"build with signing keyring only", signCmd, []string{"--keyring", "sec.gpg"}, "", false},
+		{"build signed with keyring", signCmd, []string{"--sign", "--keyring", "sec.gpg"}, "sec.gpg", true},
+		{"build signed with default keyring", signCmd, []string{"--sign"}, util.DefaultSigningKeyring(), true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cmd := c.newCmd()
+			if err := cmd.ParseFlags(c.args); err != nil {
+				t.Fatal(err)
+			}
+			keyring, check := verifyKeyring(cmd)
+			if keyring != c.expectedKeyring || check != c.expectedCheck {
+				t.Errorf("expected keyring '%s' and check %t, got '%s' and %t", c.expectedKeyring, c.expectedCheck, keyring, check)
+			}
+		})
+	}
+}
+
+func signCmd() *cobra.Command {
+	cmd := &cobra.Command{Use: "build"}
+	addSignFlags(cmd.Flags())
+	return cmd
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"extensionctl/extension"
+	"extensionctl/image"
+	"extensionctl/util"
+	"os"
+	"sort"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// completion output is read from stdout by the shell, log messages must not end up there
+func quietCompletion(cmd *cobra.Command) {
+	color.Output = os.Stderr
+	setKubeOptions(cmd)
+}
+
+func completeConfigFile(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return []string{"json"}, cobra.ShellCompDirectiveFilterFileExt
+}
+
+func completeBundle(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return []string{"tar"}, cobra.ShellCompDirectiveFilterFileExt
+}
+
+// completeExtensionChart completes the names of the charts in the extensions directory, chart tgz
+// files are completed by the shell
+func completeExtensionChart(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	quietCompletion(cmd)
+	extensionsDir, _ := cmd.Flags().GetString("extensions-dir")
+	names, err := extension.ChartNames(extensionsDir)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	return names, cobra.ShellCompDirectiveDefault
+}
+
+// completeRelease completes the names of the helm releases in the cluster
+func completeRelease(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	quietCompletion(cmd)
+	clientset, err := util.KubeClientset()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	releases, err := extension.ListReleases(clientset)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	namespace, _ := cmd.Flags().GetString("namespace")
+	names := []string{}
+	for _, rel := range releases {
+		if namespace == "" || rel.Namespace == namespace {
+			names = append(names, rel.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeImageNames completes the images of the extension in the config file and their
+// prerequisites, which are looked up by name in the Dockerfiles of kaapana_path
+func completeImageNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	quietCompletion(cmd)
+	config, err := util.ReadConfigFile(args[0])
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	if len(config.DockerfilePaths) == 0 {
+		config.DockerfilePaths, err = image.FindDockerfiles(config.DirPath)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+	}
+	dockerfiles := config.DockerfilePaths
+	if prereqDockerfiles, err := image.FindPrereqDockerfiles(config); err == nil {
+		dockerfiles = append(dockerfiles, prereqDockerfiles...)
+	}
+	byImage, err := image.DockerfilesByImage(dockerfiles)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	names := []string{}
+	for name := range byImage {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeValues(values ...string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return values, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func completeDirs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return nil, cobra.ShellCompDirectiveFilterDirs
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCompleteImageNames(t *testing.T) {
+	tmp := t.TempDir()
+	dirPath := filepath.Join(tmp, "extension")
+	kaapanaPath := filepath.Join(tmp, "kaapana")
+	writeFiles(t, dirPath, map[string]string{
+		"processing-containers/otsus-method/Dockerfile": "FROM local-only/base-python-cpu:latest\nLABEL IMAGE=\"otsus-method\"\n",
+		"extension/docker/Dockerfile":                   "FROM local-only/base-installer:latest\nLABEL IMAGE=\"dag-otsus-method\"\n",
+	})
+	// only the prerequisites of the extension are completed from kaapana_path
+	writeFiles(t, kaapanaPath, map[string]string{
+		"base-images/base-python-cpu/Dockerfile": "FROM local-only/base-core:latest\nLABEL IMAGE=\"base-python-cpu\"\n",
+		"base-images/base-core/Dockerfile":       "FROM ubuntu:22.04\nLABEL IMAGE=\"base-core\"\n",
+		"base-images/base-installer/Dockerfile":  "FROM ubuntu:22.04\nLABEL IMAGE=\"base-installer\"\n",
+		"services/unrelated/Dockerfile":          "FROM ubuntu:22.04\nLABEL IMAGE=\"unrelated\"\n",
+	})
+	configPath := filepath.Join(tmp, "config.json")
+	content, err := json.Marshal(map[string]string{"dir_path": dirPath, "kaapana_path": kaapanaPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	names, directive := completeImageNames(ImageCmd(), []string{configPath}, "")
+	expected := []string{"base-core", "base-installer", "base-python-cpu", "dag-otsus-method", "otsus-method"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+	if directive != cobra.ShellCompDirectiveNoFileComp {
+		t.Fatalf("unexpected directive %d", directive)
+	}
+
+	// the config file has to be completed first
+	if names, _ := completeImageNames(ImageCmd(), []string{}, ""); len(names) != 0 {
+		t.Fatalf("expected no image names without a config file, got %v", names)
+	}
+}
+
+func TestCompleteExtensionChart(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"otsus-method", "code-server-chart"} {
+		ch := &helmchart.Chart{Metadata: &helmchart.Metadata{APIVersion: helmchart.APIVersionV2, Name: name, Version: "0.1.0"}}
+		if _, err := chartutil.Save(ch, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := InstallCmd()
+	if err := cmd.Flags().Set("extensions-dir", dir); err != nil {
+		t.Fatal(err)
+	}
+	names, _ := completeExtensionChart(cmd, []string{}, "")
+	expected := []string{"code-server-chart", "otsus-method"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+}
+
+func TestCompleteValues(t *testing.T) {
+	values, directive := completeValues("table", "json", "yaml")(nil, nil, "")
+	if !reflect.DeepEqual(values, []string{"table", "json", "yaml"}) || directive != cobra.ShellCompDirectiveNoFileComp {
+		t.Fatalf("unexpected completion %v %d", values, directive)
+	}
+	extensions, directive := completeConfigFile(nil, []string{}, "")
+	if !reflect.DeepEqual(extensions, []string{"json"}) || directive != cobra.ShellCompDirectiveFilterFileExt {
+		t.Fatalf("unexpected completion %v %d", extensions, directive)
+	}
+}
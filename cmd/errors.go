@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"extensionctl/chart"
+	"extensionctl/image"
+	"extensionctl/util"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of extensionctl, CI scripts can react to the category of the failure. They are
+// documented in the README.
+const (
+	exitOK      = 0
+	exitError   = 1
+	exitUsage   = 2
+	exitConfig  = 3
+	exitBuild   = 4
+	exitChart   = 5
+	exitCluster = 6
+	// exitInterrupted is used after SIGINT or SIGTERM, like shells do for SIGINT
+	exitInterrupted = 130
+)
+
+// usageError is a wrong command, argument or flag on the command line
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *usageError) Unwrap() error {
+	return e.err
+}
+
+// exitCode returns the exit code for the category of err, the first typed error in the chain
+// decides, e.g. a ClusterError returned while packaging a chart exits with exitCluster
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		switch e.(type) {
+		case *usageError:
+			return exitUsage
+		case *util.ConfigError:
+			return exitConfig
+		case *image.BuildError:
+			return exitBuild
+		case *chart.ChartError:
+			return exitChart
+		case *util.ClusterError:
+			return exitCluster
+		}
+	}
+	return exitError
+}
+
+// validateUsage makes the argument and flag errors of cmd and its subcommands usage errors
+func validateUsage(cmd *cobra.Command) {
+	cmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &usageError{err: err}
+	})
+	setUsageArgs(cmd)
+}
+
+func setUsageArgs(cmd *cobra.Command) {
+	validate := cmd.Args
+	if validate == nil && !cmd.HasParent() {
+		validate = unknownCommand
+	}
+	if validate != nil {
+		cmd.Args = func(cmd *cobra.Command, args []string) error {
+			if err := validate(cmd, args); err != nil {
+				return &usageError{err: err}
+			}
+			return nil
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		setUsageArgs(sub)
+	}
+}
+
+// unknownCommand replaces the argument check cobra does for the root command if Args is not set
+func unknownCommand(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	message := fmt.Sprintf("unknown command %q for %q", args[0], cmd.CommandPath())
+	if cmd.SuggestionsMinimumDistance <= 0 {
+		cmd.SuggestionsMinimumDistance = 2
+	}
+	if suggestions := cmd.SuggestionsFor(args[0]); len(suggestions) > 0 {
+		message += "\n\nDid you mean this?\n\t" + strings.Join(suggestions, "\n\t")
+	}
+	return errors.New(message)
+}
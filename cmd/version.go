@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/spf13/cobra"
+)
+
+// set with -ldflags "-X main.version=... -X main.commit=... -X main.date=...", see the Makefile
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// buildInfo falls back to the vcs information go embeds when building inside the git repository
+func buildInfo() (string, string, string) {
+	buildCommit, buildDate := commit, date
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" && buildCommit == "" {
+				buildCommit = setting.Value
+			}
+			if setting.Key == "vcs.time" && buildDate == "" {
+				buildDate = setting.Value
+			}
+		}
+	}
+	if buildCommit == "" {
+		buildCommit = "unknown"
+	}
+	if buildDate == "" {
+		buildDate = "unknown"
+	}
+	return version, buildCommit, buildDate
+}
+
+func versionText() string {
+	v, c, d := buildInfo()
+	return fmt.Sprintf("extensionctl version %s\ncommit: %s\nbuilt: %s\ngo: %s %s/%s\n", v, c, d, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+func VersionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the version, commit, build date and Go version",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Fprint(cmd.OutOrStdout(), versionText())
+		},
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestVersionText(t *testing.T) {
+	version, commit, date = "0.3.0", "abc1234", "2024-05-01T10:00:00Z"
+	defer func() { version, commit, date = "dev", "", "" }()
+
+	v, c, d := buildInfo()
+	if v != "0.3.0" || c != "abc1234" || d != "2024-05-01T10:00:00Z" {
+		t.Fatalf("expected the values set with -ldflags, got %s %s %s", v, c, d)
+	}
+
+	var out bytes.Buffer
+	cmd := VersionCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	expected := "extensionctl version 0.3.0\ncommit: abc1234\nbuilt: 2024-05-01T10:00:00Z\ngo: " + runtime.Version() + " " + runtime.GOOS + "/" + runtime.GOARCH + "\n"
+	if out.String() != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+func TestBuildInfoUnknown(t *testing.T) {
+	// test binaries don't embed vcs information
+	_, c, d := buildInfo()
+	if c != "unknown" || d != "unknown" {
+		t.Fatalf("expected commit and date to fall back to unknown, got %q %q", c, d)
+	}
+	if !strings.HasPrefix(versionText(), "extensionctl version dev\n") {
+		t.Fatalf("unexpected version text %q", versionText())
+	}
+}
@@ -3,59 +3,107 @@ package extension
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
 
+	"extensionctl/util"
+
 	"github.com/fatih/color"
 	"gopkg.in/yaml.v2"
+	"k8s.io/client-go/kubernetes"
 )
 
 type Extension struct {
-	Name             string   `json:"name"`
-	Version          string   `json:"version"`
-	Description      string   `json:"description"`
-	HelmStatus       string   `json:"helm_status"`
-	KubernetesStatus []string `json:"kubernetes_status"`
+	Name             string           `json:"name"`
+	Version          string           `json:"version"`
+	Description      string           `json:"description"`
+	HelmStatus       string           `json:"helm_status"`
+	Releases         []ReleaseInfo    `json:"releases"`
+	KubernetesStatus []ResourceStatus `json:"kubernetes_status"`
 }
 
-func GetExtensions() ([]Extension, error) {
-	chartsDir := "/home/kaapana/extensions" // Update this with the actual path to your charts directory
+// DefaultExtensionsDir is where the Kaapana platform stores the uploaded extension charts
+const DefaultExtensionsDir = "/home/kaapana/extensions"
+
+// Summarized helm status of an extension, other helm statuses such as pending-install are kept
+// as they are. Different statuses of multiple releases are joined with ','.
+const (
+	StatusDeployed     = "deployed"
+	StatusFailed       = "failed"
+	StatusNotInstalled = "not installed"
+	StatusUnknown      = "unknown"
+)
 
-	files, err := os.ReadDir(chartsDir)
+func GetExtensions(ctx context.Context, extensionsDir string) ([]Extension, error) {
+	clientset, err := util.KubeClientset()
 	if err != nil {
-		color.Red(fmt.Sprintf("failed to read charts directory: %s", err.Error()))
-		return nil, err
+		color.Yellow("skipping the helm and kubernetes status of the extensions: %s", err.Error())
+		clientset = nil
+	}
+	return listExtensions(ctx, extensionsDir, clientset)
+}
+
+// listExtensions reads the charts in extensionsDir, the status is only looked up if clientset is set
+func listExtensions(ctx context.Context, extensionsDir string, clientset kubernetes.Interface) ([]Extension, error) {
+	if extensionsDir == "" {
+		extensionsDir = DefaultExtensionsDir
+	}
+
+	files, err := os.ReadDir(extensionsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read extensions directory: %w", err)
+	}
+
+	var releases []ReleaseInfo
+	if clientset != nil {
+		releases, err = ListReleases(clientset)
+		if err != nil {
+			color.Yellow(err.Error())
+		}
 	}
 
 	extensions := make([]Extension, 0)
+	var resources *KubernetesResources
+	var resourcesErr error
 
 	for _, file := range files {
-		if file.IsDir() {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".tgz") {
 			continue
 		}
 
-		ext, err := extractExtensionInfo(filepath.Join(chartsDir, file.Name()))
+		ext, err := extractExtensionInfo(filepath.Join(extensionsDir, file.Name()))
 		if err != nil {
-			fmt.Printf("Error extracting extension info: %v", err)
+			color.Yellow("skipping %s, failed to extract extension info: %s", file.Name(), err.Error())
 			continue
 		}
 
-		helmStatus, err := getHelmStatus(ext.Name)
-		if err != nil {
-			fmt.Printf("Error getting Helm status for %s: %v", ext.Name, err)
+		ext.HelmStatus = StatusUnknown
+		ext.Releases = []ReleaseInfo{}
+		ext.KubernetesStatus = []ResourceStatus{}
+		if releases == nil {
+			extensions = append(extensions, ext)
+			continue
 		}
-		ext.HelmStatus = helmStatus
-
-		kubernetesStatus, err := getKubernetesStatus(ext.Name)
-		if err != nil {
-			fmt.Printf("Error getting Kubernetes status: %v", err)
+		ext.Releases = ReleasesOfChart(releases, ext.Name, ext.Version)
+		ext.HelmStatus = summarizeStatus(ext.Releases)
+
+		if len(ext.Releases) > 0 && resources == nil && resourcesErr == nil {
+			// listed once for all releases
+			resources, resourcesErr = ListKubernetesResources(ctx, clientset)
+			if resourcesErr != nil {
+				color.Yellow("failed to get kubernetes status: %s", resourcesErr.Error())
+			}
+		}
+		if resources != nil {
+			for _, rel := range ext.Releases {
+				ext.KubernetesStatus = append(ext.KubernetesStatus, resources.Status(rel.Name)...)
+			}
 		}
-		ext.KubernetesStatus = kubernetesStatus
 
 		extensions = append(extensions, ext)
 	}
@@ -68,15 +116,13 @@ func extractExtensionInfo(filePath string) (Extension, error) {
 
 	file, err := os.Open(filePath)
 	if err != nil {
-		color.Red(fmt.Sprintf("failed to open file: %s", err.Error()))
-		return ext, err
+		return ext, fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
 	gzr, err := gzip.NewReader(file)
 	if err != nil {
-		color.Red(fmt.Sprintf("failed to create gzip reader: %s", err.Error()))
-		return ext, err
+		return ext, fmt.Errorf("failed to create gzip reader: %w", err)
 	}
 	defer gzr.Close()
 
@@ -91,8 +137,7 @@ func extractExtensionInfo(filePath string) (Extension, error) {
 			break
 		}
 		if err != nil {
-			color.Red(fmt.Sprintf("failed to read tar header: %s", err.Error()))
-			return ext, err
+			return ext, fmt.Errorf("failed to read tar header: %w", err)
 		}
 
 		fileName := path.Base(header.Name)
@@ -102,8 +147,7 @@ func extractExtensionInfo(filePath string) (Extension, error) {
 			// Read and parse the Chart.yaml file to extract extension information
 			chartData, err := extractFileContents(tarReader)
 			if err != nil {
-				color.Red(fmt.Sprintf("failed to extract Chart.yaml contents: %s", err.Error()))
-				return ext, err
+				return ext, fmt.Errorf("failed to extract Chart.yaml contents: %w", err)
 			}
 
 			var chartMetadata struct {
@@ -113,8 +157,7 @@ func extractExtensionInfo(filePath string) (Extension, error) {
 			}
 			err = yaml.Unmarshal([]byte(chartData), &chartMetadata)
 			if err != nil {
-				color.Red(fmt.Sprintf("failed to unmarshal Chart.yaml: %s", err.Error()))
-				return ext, err
+				return ext, fmt.Errorf("failed to unmarshal Chart.yaml: %w", err)
 			}
 
 			ext.Name = chartMetadata.Name
@@ -127,14 +170,12 @@ func extractExtensionInfo(filePath string) (Extension, error) {
 			// Read and parse the values.yaml file to extract additional extension information
 			valuesData, err := extractFileContents(tarReader)
 			if err != nil {
-				color.Red(fmt.Sprintf("failed to extract values.yaml contents: %s", err.Error()))
-				return ext, err
+				return ext, fmt.Errorf("failed to extract values.yaml contents: %w", err)
 			}
 
 			_, err = parseValuesYAML(valuesData)
 			if err != nil {
-				color.Red(fmt.Sprintf("failed to parse values.yaml: %s", err.Error()))
-				return ext, err
+				return ext, fmt.Errorf("failed to parse values.yaml: %w", err)
 			}
 
 			// // Extract additional extension information from the values map
@@ -158,8 +199,7 @@ func extractExtensionInfo(filePath string) (Extension, error) {
 func extractFileContents(tarReader *tar.Reader) (string, error) {
 	data, err := io.ReadAll(tarReader)
 	if err != nil {
-		color.Red(fmt.Sprintf("failed to read file contents: %s", err))
-		return "", err
+		return "", fmt.Errorf("failed to read file contents: %w", err)
 	}
 	return string(data), nil
 }
@@ -168,68 +208,8 @@ func parseValuesYAML(valuesData string) (interface{}, error) {
 	var values interface{}
 	err := yaml.Unmarshal([]byte(valuesData), &values)
 	if err != nil {
-		color.Red(fmt.Sprintf("failed to parse values.yaml: %s", err))
-		return nil, err
+		return nil, fmt.Errorf("failed to parse values.yaml: %w", err)
 	}
 
 	return values, nil
 }
-
-func getHelmStatus(extensionName string) (string, error) {
-	// Run helm status command and capture the output
-	cmd := exec.Command("helm", "status", extensionName)
-	output, err := cmd.Output()
-	if err != nil {
-		// Check if the command returned a non-zero exit code
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			color.Red(fmt.Sprintf("helm status command failed with exit code %d: %s", exitErr.ExitCode(), exitErr.Stderr))
-			return "", err
-		}
-		color.Red(fmt.Sprintf("failed to execute helm status command: %s", err.Error()))
-		return "", err
-	}
-
-	// Extract the Helm status from the output
-	status := string(output)
-
-	return status, nil
-}
-
-func getKubernetesStatus(extensionName string) ([]string, error) {
-	// Run kubectl get command and capture the output
-	cmd := exec.Command("microk8s.kubectl", "get", "all")
-	output, err := cmd.Output()
-	if err != nil {
-		color.Red(fmt.Sprintf("failed to execute kubectl get command: %s", err))
-		return nil, err
-	}
-
-	// Process the output and extract the resource statuses
-	statuses := parseKubernetesStatus(string(output))
-
-	return statuses, nil
-}
-
-func parseKubernetesStatus(output string) []string {
-	lines := strings.Split(output, "\n")
-	statuses := make([]string, 0)
-
-	// Iterate over the lines and extract the resource statuses
-	for _, line := range lines {
-		// Skip empty lines
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-
-		// Split the line by whitespace
-		fields := strings.Fields(line)
-
-		// The status is typically in the third column
-		if len(fields) >= 3 {
-			status := fields[2]
-			statuses = append(statuses, status)
-		}
-	}
-
-	return statuses
-}
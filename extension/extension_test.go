@@ -1,26 +1,408 @@
 package extension
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/action"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/kube"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	rspb "helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	helmtime "helm.sh/helm/v3/pkg/time"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"extensionctl/util"
 )
 
+func writeChartTgz(t *testing.T, path string, chartYaml string) {
+	t.Helper()
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	files := map[string]string{
+		"otsus-method/Chart.yaml":  chartYaml,
+		"otsus-method/values.yaml": "global:\n  pull_policy_images: IfNotPresent\n",
+	}
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gzw.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestGetExtensions(t *testing.T) {
-	extensions, err := GetExtensions()
+	dir := t.TempDir()
+	writeChartTgz(t, filepath.Join(dir, "otsus-method-0.1.0.tgz"), "apiVersion: v2\nname: otsus-method\nversion: 0.1.0\ndescription: Otsu's method\n")
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a chart"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	writeChartTgz(t, filepath.Join(dir, "code-server-0.2.0.tgz"), "apiVersion: v2\nname: code-server\nversion: 0.2.0\n")
+
+	// without a cluster the status is unknown
+	extensions, err := listExtensions(context.Background(), dir, nil)
+	if err != nil {
+		t.Fatalf("Failed to get extensions: %v", err)
+	}
+	if len(extensions) != 2 {
+		t.Fatalf("expected 2 extensions, got %d", len(extensions))
+	}
+	ext := extensions[1]
+	if ext.Name != "otsus-method" || ext.Version != "0.1.0" || ext.Description != "Otsu's method" || ext.HelmStatus != StatusUnknown {
+		t.Fatalf("unexpected extension %+v", ext)
+	}
+
+	clientset := fake.NewSimpleClientset()
+	createRelease(t, clientset, "otsus-method", "services", "0.1.0", 1, rspb.StatusDeployed)
+	extensions, err = listExtensions(context.Background(), dir, clientset)
 	if err != nil {
 		t.Fatalf("Failed to get extensions: %v", err)
 	}
+	if extensions[0].HelmStatus != StatusNotInstalled || extensions[1].HelmStatus != StatusDeployed {
+		t.Fatalf("unexpected status %s / %s", extensions[0].HelmStatus, extensions[1].HelmStatus)
+	}
+
+	if _, err := listExtensions(context.Background(), filepath.Join(dir, "missing"), nil); err == nil {
+		t.Fatalf("expected an error for a missing extensions directory")
+	}
+}
+
+func TestGetExtensionsVersions(t *testing.T) {
+	dir := t.TempDir()
+	writeChartTgz(t, filepath.Join(dir, "otsus-method-0.1.0.tgz"), "apiVersion: v2\nname: otsus-method\nversion: 0.1.0\n")
+	writeChartTgz(t, filepath.Join(dir, "otsus-method-0.2.0.tgz"), "apiVersion: v2\nname: otsus-method\nversion: 0.2.0\n")
 
-	fmt.Printf("Found %d extensions:\n", len(extensions))
+	// only the older version is installed, the newer one is only packaged
+	clientset := fake.NewSimpleClientset()
+	createRelease(t, clientset, "otsus-method", "services", "0.1.0", 1, rspb.StatusDeployed)
+	extensions, err := listExtensions(context.Background(), dir, clientset)
+	if err != nil {
+		t.Fatalf("Failed to get extensions: %v", err)
+	}
+	if len(extensions) != 2 {
+		t.Fatalf("expected 2 extensions, got %d", len(extensions))
+	}
 	for _, ext := range extensions {
-		fmt.Printf("Name: %s\n", ext.Name)
-		fmt.Printf("Version: %s\n", ext.Version)
-		fmt.Printf("Description: %s\n", ext.Description)
-		fmt.Printf("Helm Status: %s\n", ext.HelmStatus)
-		fmt.Printf("Kubernetes Status:\n")
-		for _, status := range ext.KubernetesStatus {
-			fmt.Printf("- %s\n", status)
+		switch ext.Version {
+		case "0.1.0":
+			if ext.HelmStatus != StatusDeployed || len(ext.Releases) != 1 || ext.Releases[0].ChartVersion != "0.1.0" {
+				t.Errorf("unexpected status %s and releases %+v of 0.1.0", ext.HelmStatus, ext.Releases)
+			}
+		case "0.2.0":
+			if ext.HelmStatus != StatusNotInstalled || len(ext.Releases) != 0 {
+				t.Errorf("unexpected status %s and releases %+v of 0.2.0", ext.HelmStatus, ext.Releases)
+			}
+		default:
+			t.Errorf("unexpected extension %+v", ext)
 		}
-		fmt.Println("--------")
+	}
+}
+
+func createRelease(t *testing.T, clientset *fake.Clientset, name string, namespace string, chartVersion string, revision int, status rspb.Status) {
+	t.Helper()
+	rel := &rspb.Release{
+		Name:      name,
+		Namespace: namespace,
+		Version:   revision,
+		Info:      &rspb.Info{Status: status, LastDeployed: helmtime.Date(2023, 12, 1, 10, 0, 0, 0, time.UTC)},
+		Chart:     &helmchart.Chart{Metadata: &helmchart.Metadata{Name: "otsus-method", Version: chartVersion, AppVersion: "1.0"}},
+	}
+	secrets := driver.NewSecrets(clientset.CoreV1().Secrets(namespace))
+	if err := secrets.Create(fmt.Sprintf("sh.helm.release.v1.%s.v%d", name, revision), rel); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListReleases(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	// a multi-installable extension installed twice, the first release was upgraded
+	createRelease(t, clientset, "otsus-method", "services", "0.1.0", 1, rspb.StatusSuperseded)
+	createRelease(t, clientset, "otsus-method", "services", "0.1.0", 2, rspb.StatusDeployed)
+	createRelease(t, clientset, "otsus-method-b3f1", "project-a", "0.1.0", 1, rspb.StatusFailed)
+
+	releases, err := ListReleases(clientset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	releases = ReleasesOfChart(releases, "otsus-method", "0.1.0")
+	if len(releases) != 2 {
+		t.Fatalf("expected 2 releases, got %+v", releases)
+	}
+	expected := ReleaseInfo{
+		Name:         "otsus-method",
+		Namespace:    "services",
+		Status:       "deployed",
+		Revision:     2,
+		Chart:        "otsus-method",
+		ChartVersion: "0.1.0",
+		AppVersion:   "1.0",
+		LastDeployed: time.Date(2023, 12, 1, 10, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(releases[0], expected) {
+		t.Fatalf("expected %+v, got %+v", expected, releases[0])
+	}
+	if releases[1].Namespace != "project-a" || releases[1].Status != "failed" {
+		t.Fatalf("unexpected release %+v", releases[1])
+	}
+	if status := summarizeStatus(releases); status != "deployed,failed" {
+		t.Fatalf("unexpected summarized status %s", status)
+	}
+	if len(ReleasesOfChart(releases, "code-server", "0.1.0")) != 0 {
+		t.Fatalf("expected no releases of code-server")
+	}
+}
+
+func TestPrintExtensions(t *testing.T) {
+	extensions := []Extension{
+		{Name: "otsus-method", Version: "0.1.0", Description: "Otsu's method", HelmStatus: StatusDeployed, KubernetesStatus: []ResourceStatus{{Kind: "pod", Namespace: "services", Name: "otsus-method-7d9f", Ready: "1/1", Phase: "Running"}}},
+		{Name: "code-server", Version: "0.2.0", HelmStatus: StatusNotInstalled},
+	}
+
+	var table bytes.Buffer
+	if err := PrintExtensions(&table, extensions, "table"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "NAME") || !strings.Contains(lines[2], "not installed") {
+		t.Fatalf("unexpected table:\n%s", table.String())
+	}
+	if strings.Contains(table.String(), "Otsu's method") {
+		t.Fatalf("description should only be printed with -o wide:\n%s", table.String())
+	}
+
+	var wide bytes.Buffer
+	if err := PrintExtensions(&wide, extensions, "wide"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(wide.String(), "Otsu's method") || !strings.Contains(wide.String(), "Running") {
+		t.Fatalf("unexpected wide table:\n%s", wide.String())
+	}
+
+	var out bytes.Buffer
+	if err := PrintExtensions(&out, extensions, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var parsed []Extension
+	if err := json.Unmarshal(out.Bytes(), &parsed); err != nil {
+		t.Fatalf("invalid json output: %v", err)
+	}
+	if len(parsed) != 2 || parsed[0].HelmStatus != StatusDeployed {
+		t.Fatalf("unexpected json output %s", out.String())
+	}
+
+	out.Reset()
+	if err := PrintExtensions(&out, extensions, "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "helm_status: not installed") {
+		t.Fatalf("yaml output should use the json tags:\n%s", out.String())
+	}
+
+	if err := PrintExtensions(&out, extensions, "xml"); err == nil {
+		t.Fatalf("expected an error for an unknown output format")
+	}
+}
+
+func TestGetKubernetesStatus(t *testing.T) {
+	replicas := int32(2)
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "otsus-method-7d9f", Namespace: "services", Labels: map[string]string{"app.kubernetes.io/instance": "otsus-method"}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}, {Name: "sidecar"}}},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "app", Ready: true, RestartCount: 1},
+					{Name: "sidecar", RestartCount: 3, State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}},
+				},
+			},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "otsus-method", Namespace: "services", Annotations: map[string]string{"meta.helm.sh/release-name": "otsus-method"}},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status:     appsv1.DeploymentStatus{ReadyReplicas: 1},
+		},
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "otsus-method-dag-installer", Namespace: "admin", Labels: map[string]string{"app.kubernetes.io/instance": "otsus-method"}},
+			Status:     batchv1.JobStatus{Succeeded: 1, Conditions: []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "otsus-method", Namespace: "services", Labels: map[string]string{"app.kubernetes.io/instance": "otsus-method"}},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP},
+		},
+		// resources of other releases are not reported
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "code-server-5c4b", Namespace: "services", Labels: map[string]string{"app.kubernetes.io/instance": "code-server"}}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "default"}},
+	)
+
+	statuses, err := GetKubernetesStatus(context.Background(), clientset, "otsus-method")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ResourceStatus{
+		{Kind: "job", Namespace: "admin", Name: "otsus-method-dag-installer", Ready: "1/1", Phase: "Complete"},
+		{Kind: "deployment", Namespace: "services", Name: "otsus-method", Ready: "1/2", Phase: "Unavailable"},
+		{Kind: "pod", Namespace: "services", Name: "otsus-method-7d9f", Ready: "1/2", Restarts: 4, Phase: "CrashLoopBackOff"},
+		{Kind: "service", Namespace: "services", Name: "otsus-method", Phase: "ClusterIP"},
+	}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Fatalf("expected\n%+v\ngot\n%+v", expected, statuses)
+	}
+}
+
+func testActionConfiguration() *action.Configuration {
+	return &action.Configuration{
+		Releases:     storage.Init(driver.NewMemory()),
+		KubeClient:   &kubefake.PrintingKubeClient{Out: io.Discard},
+		Capabilities: chartutil.DefaultCapabilities,
+		Log:          func(format string, v ...interface{}) {},
+	}
+}
+
+func TestNewActionConfigurationNamespace(t *testing.T) {
+	// the current context of the kubeconfig points to another namespace than the release
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	content := `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:6443
+users:
+- name: test
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+    namespace: other
+current-context: test
+`
+	if err := os.WriteFile(kubeconfig, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	util.SetKubeOptions(util.KubeOptions{Kubeconfig: kubeconfig})
+	defer util.SetKubeOptions(util.KubeOptions{})
+
+	helmConfig, err := NewActionConfiguration("project-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the kube client creates the resources of the release in the namespace it resolves from its factory
+	client, ok := helmConfig.KubeClient.(*kube.Client)
+	if !ok {
+		t.Fatalf("unexpected kube client %T", helmConfig.KubeClient)
+	}
+	namespace, _, err := client.Factory.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if namespace != "project-a" {
+		t.Fatalf("expected resources to be created in project-a, got %s", namespace)
+	}
+}
+
+func TestLoadExtensionChart(t *testing.T) {
+	dir := t.TempDir()
+	writeChartTgz(t, filepath.Join(dir, "otsus-method-0.1.0.tgz"), "apiVersion: v2\nname: otsus-method\nversion: 0.1.0\n")
+	writeChartTgz(t, filepath.Join(dir, "otsus-method-0.10.0.tgz"), "apiVersion: v2\nname: otsus-method\nversion: 0.10.0\n")
+
+	ch, err := LoadExtensionChart("otsus-method", "", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.Metadata.Version != "0.10.0" {
+		t.Fatalf("expected the highest version 0.10.0, got %s", ch.Metadata.Version)
+	}
+	ch, err = LoadExtensionChart("otsus-method", "0.1.0", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.Metadata.Version != "0.1.0" {
+		t.Fatalf("expected version 0.1.0, got %s", ch.Metadata.Version)
+	}
+	if _, err := LoadExtensionChart(filepath.Join(dir, "otsus-method-0.1.0.tgz"), "", ""); err != nil {
+		t.Fatalf("failed to load chart by path: %v", err)
+	}
+	if _, err := LoadExtensionChart("code-server", "", dir); err == nil {
+		t.Fatalf("expected an error for a missing chart")
+	}
+}
+
+func TestInstallUpgradeUninstall(t *testing.T) {
+	dir := t.TempDir()
+	chartPath := filepath.Join(dir, "otsus-method-0.1.0.tgz")
+	writeChartTgz(t, chartPath, "apiVersion: v2\nname: otsus-method\nversion: 0.1.0\n")
+	ch, err := LoadExtensionChart(chartPath, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helmConfig := testActionConfiguration()
+	// credentials in the env of kube-helm are not passed to the release
+	global := PlatformGlobalValues(map[string]string{"REGISTRY_URL": "registry.example.com/kaapana", "KAAPANA_BUILD_VERSION": "0.2.0", "SERVICES_NAMESPACE": "services", "REGISTRY_PASSWORD": "secret"})
+	opts := InstallOptions{Namespace: "default", Wait: true, Timeout: DefaultTimeout, Set: []string{"global.pull_policy_images=Always"}}
+
+	rel, err := Install(context.Background(), helmConfig, ch, global, opts)
+	if err != nil {
+		t.Fatalf("failed to install: %v", err)
+	}
+	if rel.Name != "otsus-method" || rel.Info.Status != rspb.StatusDeployed {
+		t.Fatalf("unexpected release %s %s", rel.Name, rel.Info.Status)
+	}
+	expected := map[string]interface{}{
+		"registry_url":          "registry.example.com/kaapana",
+		"kaapana_build_version": "0.2.0",
+		"services_namespace":    "services",
+		"pull_policy_images":    "Always",
+	}
+	if !reflect.DeepEqual(rel.Config["global"], expected) {
+		t.Fatalf("expected global values %v, got %v", expected, rel.Config["global"])
+	}
+
+	if _, err := Install(context.Background(), helmConfig, ch, global, opts); err == nil {
+		t.Fatalf("expected installing an existing release to fail")
+	}
+
+	rel, err = Upgrade(context.Background(), helmConfig, ch, global, opts)
+	if err != nil {
+		t.Fatalf("failed to upgrade: %v", err)
+	}
+	if rel.Version != 2 {
+		t.Fatalf("expected revision 2, got %d", rel.Version)
+	}
+
+	if err := Uninstall(helmConfig, "otsus-method", opts); err != nil {
+		t.Fatalf("failed to uninstall: %v", err)
+	}
+	if _, err := helmConfig.Releases.Deployed("otsus-method"); err == nil {
+		t.Fatalf("release is still deployed after uninstall")
 	}
 }
@@ -0,0 +1,236 @@
+package extension
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"extensionctl/util"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/fatih/color"
+	"helm.sh/helm/v3/pkg/action"
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	rspb "helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/strvals"
+)
+
+// DefaultTimeout is how long install, upgrade and uninstall wait for the resources of a release
+const DefaultTimeout = 5 * time.Minute
+
+type InstallOptions struct {
+	// ReleaseName defaults to the chart name
+	ReleaseName string
+	Namespace   string
+	// Version selects the chart version if the chart is referenced by name
+	Version       string
+	ExtensionsDir string
+	// Set are values in the format of 'helm --set', they override the global values
+	Set     []string
+	Wait    bool
+	Timeout time.Duration
+}
+
+// platformGlobalEnv are the env variables of the kube-helm deployment that the platform passes
+// to extensions, besides the *_NAMESPACE variables. Other variables, e.g. credentials resolved
+// from Secrets, must not end up in the values of every release.
+var platformGlobalEnv = map[string]bool{
+	"REGISTRY_URL":                   true,
+	"KAAPANA_BUILD_VERSION":          true,
+	"PLATFORM_BUILD_BRANCH":          true,
+	"PLATFORM_LAST_COMMIT_TIMESTAMP": true,
+	"BUILD_TIMESTAMP":                true,
+	"OFFLINE_MODE":                   true,
+	"PULL_POLICY_IMAGES":             true,
+}
+
+// PlatformGlobalValues returns the 'global' values the kube-helm backend of the platform passes to
+// extensions: the versions, registry and namespaces from the env of the kube-helm deployment as
+// lowercase keys
+func PlatformGlobalValues(env map[string]string) map[string]interface{} {
+	global := map[string]interface{}{}
+	for name, value := range env {
+		if platformGlobalEnv[name] || strings.HasSuffix(name, "_NAMESPACE") {
+			global[strings.ToLower(name)] = value
+		}
+	}
+	return global
+}
+
+// NewActionConfiguration initializes helm for the namespace of the release, releases are stored as secrets
+func NewActionConfiguration(namespace string) (*action.Configuration, error) {
+	kubeOptions := util.GetKubeOptions()
+	settings := cli.New()
+	if kubeOptions.Kubeconfig != "" {
+		settings.KubeConfig = kubeOptions.Kubeconfig
+	}
+	if kubeOptions.Context != "" {
+		settings.KubeContext = kubeOptions.Context
+	}
+	// otherwise the resources of the release are created in the namespace of the kubeconfig context
+	settings.SetNamespace(namespace)
+	helmConfig := new(action.Configuration)
+	err := helmConfig.Init(settings.RESTClientGetter(), namespace, "secret", func(format string, v ...interface{}) {})
+	if err != nil {
+		return nil, &util.ClusterError{Err: fmt.Errorf("failed to initialize helm configuration: %w", err)}
+	}
+	return helmConfig, nil
+}
+
+// LoadExtensionChart loads a chart tgz, or the chart with this name from the extensions directory.
+// Without a version the highest version in the directory is used.
+func LoadExtensionChart(chartRef string, version string, extensionsDir string) (*helmchart.Chart, error) {
+	if strings.HasSuffix(chartRef, ".tgz") || strings.ContainsRune(chartRef, os.PathSeparator) {
+		ch, err := loader.Load(chartRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load chart %s: %w", chartRef, err)
+		}
+		return ch, nil
+	}
+
+	if extensionsDir == "" {
+		extensionsDir = DefaultExtensionsDir
+	}
+	files, err := filepath.Glob(filepath.Join(extensionsDir, "*.tgz"))
+	if err != nil {
+		return nil, err
+	}
+	var found string
+	var foundVersion *semver.Version
+	for _, file := range files {
+		ext, err := extractExtensionInfo(file)
+		if err != nil || ext.Name != chartRef {
+			continue
+		}
+		if version != "" {
+			if ext.Version == version {
+				found = file
+				break
+			}
+			continue
+		}
+		v, err := semver.NewVersion(ext.Version)
+		if err != nil {
+			color.Yellow("ignoring %s, version %s is not a semantic version", file, ext.Version)
+			continue
+		}
+		if foundVersion == nil || v.GreaterThan(foundVersion) {
+			found, foundVersion = file, v
+		}
+	}
+	if found == "" {
+		if version != "" {
+			return nil, fmt.Errorf("chart %s %s not found in %s", chartRef, version, extensionsDir)
+		}
+		return nil, fmt.Errorf("chart %s not found in %s", chartRef, extensionsDir)
+	}
+
+	color.Blue("using chart %s", found)
+	ch, err := loader.Load(found)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart %s: %w", found, err)
+	}
+	return ch, nil
+}
+
+// ChartNames returns the names of the extension charts in the extensions directory
+func ChartNames(extensionsDir string) ([]string, error) {
+	if extensionsDir == "" {
+		extensionsDir = DefaultExtensionsDir
+	}
+	files, err := filepath.Glob(filepath.Join(extensionsDir, "*.tgz"))
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	seen := map[string]bool{}
+	for _, file := range files {
+		ext, err := extractExtensionInfo(file)
+		if err != nil || seen[ext.Name] {
+			continue
+		}
+		seen[ext.Name] = true
+		names = append(names, ext.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// releaseValues puts the platform values under 'global' and applies the --set values on top
+func releaseValues(global map[string]interface{}, set []string) (map[string]interface{}, error) {
+	values := map[string]interface{}{"global": global}
+	for _, value := range set {
+		if err := strvals.ParseInto(value, values); err != nil {
+			return nil, fmt.Errorf("failed to parse --set %s: %w", value, err)
+		}
+	}
+	return values, nil
+}
+
+func Install(ctx context.Context, helmConfig *action.Configuration, ch *helmchart.Chart, global map[string]interface{}, opts InstallOptions) (*rspb.Release, error) {
+	values, err := releaseValues(global, opts.Set)
+	if err != nil {
+		return nil, err
+	}
+
+	install := action.NewInstall(helmConfig)
+	install.ReleaseName = opts.ReleaseName
+	if install.ReleaseName == "" {
+		install.ReleaseName = ch.Metadata.Name
+	}
+	install.Namespace = opts.Namespace
+	install.Wait = opts.Wait
+	install.Timeout = opts.Timeout
+
+	color.Blue("installing %s %s as release %s in namespace %s", ch.Metadata.Name, ch.Metadata.Version, install.ReleaseName, opts.Namespace)
+	rel, err := install.RunWithContext(ctx, ch, values)
+	if err != nil {
+		return nil, &util.ClusterError{Err: fmt.Errorf("failed to install %s: %w", install.ReleaseName, err)}
+	}
+	return rel, nil
+}
+
+func Upgrade(ctx context.Context, helmConfig *action.Configuration, ch *helmchart.Chart, global map[string]interface{}, opts InstallOptions) (*rspb.Release, error) {
+	values, err := releaseValues(global, opts.Set)
+	if err != nil {
+		return nil, err
+	}
+
+	releaseName := opts.ReleaseName
+	if releaseName == "" {
+		releaseName = ch.Metadata.Name
+	}
+	upgrade := action.NewUpgrade(helmConfig)
+	upgrade.Namespace = opts.Namespace
+	upgrade.Wait = opts.Wait
+	upgrade.Timeout = opts.Timeout
+
+	color.Blue("upgrading release %s in namespace %s to %s %s", releaseName, opts.Namespace, ch.Metadata.Name, ch.Metadata.Version)
+	rel, err := upgrade.RunWithContext(ctx, releaseName, ch, values)
+	if err != nil {
+		return nil, &util.ClusterError{Err: fmt.Errorf("failed to upgrade %s: %w", releaseName, err)}
+	}
+	return rel, nil
+}
+
+func Uninstall(helmConfig *action.Configuration, releaseName string, opts InstallOptions) error {
+	if releaseName == "" {
+		return errors.New("release name is empty")
+	}
+	uninstall := action.NewUninstall(helmConfig)
+	uninstall.Wait = opts.Wait
+	uninstall.Timeout = opts.Timeout
+
+	color.Blue("uninstalling release %s from namespace %s", releaseName, opts.Namespace)
+	if _, err := uninstall.Run(releaseName); err != nil {
+		return &util.ClusterError{Err: fmt.Errorf("failed to uninstall %s: %w", releaseName, err)}
+	}
+	return nil
+}
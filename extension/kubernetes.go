@@ -0,0 +1,185 @@
+package extension
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	releaseInstanceLabel  = "app.kubernetes.io/instance"
+	releaseNameAnnotation = "meta.helm.sh/release-name"
+)
+
+// ResourceStatus is the status of a resource that belongs to a helm release
+type ResourceStatus struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Ready is ready/desired for pods (containers) and deployments (replicas), succeeded/completions for jobs
+	Ready    string `json:"ready,omitempty"`
+	Restarts int32  `json:"restarts"`
+	// Phase is the pod phase (or the reason a container is waiting), Available/Unavailable for
+	// deployments, Complete/Failed/Running for jobs and the type of services
+	Phase string `json:"phase"`
+}
+
+func (status ResourceStatus) String() string {
+	text := fmt.Sprintf("%s/%s %s", status.Kind, status.Name, status.Phase)
+	if status.Ready != "" {
+		text += " " + status.Ready
+	}
+	if status.Kind == "pod" {
+		text += fmt.Sprintf(" restarts=%d", status.Restarts)
+	}
+	return text
+}
+
+// belongsToRelease checks the instance label set by most charts and the annotation helm sets
+// on the resources it creates
+func belongsToRelease(meta metav1.ObjectMeta, release string) bool {
+	return meta.Labels[releaseInstanceLabel] == release || meta.Annotations[releaseNameAnnotation] == release
+}
+
+func podStatus(pod corev1.Pod) ResourceStatus {
+	status := ResourceStatus{Kind: "pod", Namespace: pod.Namespace, Name: pod.Name, Phase: string(pod.Status.Phase)}
+	ready := 0
+	for _, container := range pod.Status.ContainerStatuses {
+		if container.Ready {
+			ready++
+		}
+		status.Restarts += container.RestartCount
+		if container.State.Waiting != nil && container.State.Waiting.Reason != "" {
+			status.Phase = container.State.Waiting.Reason
+		}
+	}
+	status.Ready = fmt.Sprintf("%d/%d", ready, len(pod.Spec.Containers))
+	return status
+}
+
+func deploymentStatus(deployment appsv1.Deployment) ResourceStatus {
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	status := ResourceStatus{
+		Kind:      "deployment",
+		Namespace: deployment.Namespace,
+		Name:      deployment.Name,
+		Ready:     fmt.Sprintf("%d/%d", deployment.Status.ReadyReplicas, replicas),
+		Phase:     "Unavailable",
+	}
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentAvailable && condition.Status == corev1.ConditionTrue {
+			status.Phase = "Available"
+		}
+	}
+	return status
+}
+
+func jobStatus(job batchv1.Job) ResourceStatus {
+	completions := int32(1)
+	if job.Spec.Completions != nil {
+		completions = *job.Spec.Completions
+	}
+	status := ResourceStatus{
+		Kind:      "job",
+		Namespace: job.Namespace,
+		Name:      job.Name,
+		Ready:     fmt.Sprintf("%d/%d", job.Status.Succeeded, completions),
+		Phase:     "Running",
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		if condition.Type == batchv1.JobComplete {
+			status.Phase = "Complete"
+		} else if condition.Type == batchv1.JobFailed {
+			status.Phase = "Failed"
+		}
+	}
+	return status
+}
+
+// KubernetesResources are the pods, deployments, jobs and services in all namespaces, listed
+// once and shared by the releases. Extensions create resources outside the namespace of their
+// release, e.g. the dag-installer job, so the resources can not be listed per namespace.
+type KubernetesResources struct {
+	Pods        []corev1.Pod
+	Deployments []appsv1.Deployment
+	Jobs        []batchv1.Job
+	Services    []corev1.Service
+}
+
+// ListKubernetesResources lists the resources that are reported for the releases
+func ListKubernetesResources(ctx context.Context, clientset kubernetes.Interface) (*KubernetesResources, error) {
+	pods, err := clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	deployments, err := clientset.AppsV1().Deployments("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %w", err)
+	}
+	jobs, err := clientset.BatchV1().Jobs("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	services, err := clientset.CoreV1().Services("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %w", err)
+	}
+	return &KubernetesResources{Pods: pods.Items, Deployments: deployments.Items, Jobs: jobs.Items, Services: services.Items}, nil
+}
+
+// GetKubernetesStatus lists the pods, deployments, jobs and services of a helm release in all namespaces
+func GetKubernetesStatus(ctx context.Context, clientset kubernetes.Interface, release string) ([]ResourceStatus, error) {
+	resources, err := ListKubernetesResources(ctx, clientset)
+	if err != nil {
+		return nil, err
+	}
+	return resources.Status(release), nil
+}
+
+// Status returns the status of the resources that belong to a helm release
+func (resources *KubernetesResources) Status(release string) []ResourceStatus {
+	statuses := []ResourceStatus{}
+	for _, pod := range resources.Pods {
+		if belongsToRelease(pod.ObjectMeta, release) {
+			statuses = append(statuses, podStatus(pod))
+		}
+	}
+	for _, deployment := range resources.Deployments {
+		if belongsToRelease(deployment.ObjectMeta, release) {
+			statuses = append(statuses, deploymentStatus(deployment))
+		}
+	}
+	for _, job := range resources.Jobs {
+		if belongsToRelease(job.ObjectMeta, release) {
+			statuses = append(statuses, jobStatus(job))
+		}
+	}
+	for _, service := range resources.Services {
+		if belongsToRelease(service.ObjectMeta, release) {
+			statuses = append(statuses, ResourceStatus{Kind: "service", Namespace: service.Namespace, Name: service.Name, Phase: string(service.Spec.Type)})
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Namespace != statuses[j].Namespace {
+			return statuses[i].Namespace < statuses[j].Namespace
+		}
+		if statuses[i].Kind != statuses[j].Kind {
+			return statuses[i].Kind < statuses[j].Kind
+		}
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
@@ -0,0 +1,68 @@
+package extension
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"sigs.k8s.io/yaml"
+)
+
+// OutputFormats are the formats supported by PrintExtensions
+var OutputFormats = []string{"table", "wide", "json", "yaml"}
+
+// PrintExtensions writes the extensions to w as a table (wide adds the releases, the description
+// and the kubernetes status), or as json or yaml using the json tags of Extension
+func PrintExtensions(w io.Writer, extensions []Extension, format string) error {
+	switch format {
+	case "", "table", "wide":
+		tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+		if format == "wide" {
+			fmt.Fprintln(tw, "NAME\tVERSION\tSTATUS\tRELEASES\tDESCRIPTION\tKUBERNETES STATUS")
+		} else {
+			fmt.Fprintln(tw, "NAME\tVERSION\tSTATUS")
+		}
+		for _, ext := range extensions {
+			if format == "wide" {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", ext.Name, ext.Version, ext.HelmStatus, releasesSummary(ext.Releases), ext.Description, kubernetesSummary(ext.KubernetesStatus))
+			} else {
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", ext.Name, ext.Version, ext.HelmStatus)
+			}
+		}
+		return tw.Flush()
+	case "json":
+		content, err := json.MarshalIndent(extensions, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(content))
+		return err
+	case "yaml":
+		content, err := yaml.Marshal(extensions)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(content)
+		return err
+	default:
+		return fmt.Errorf("unknown output format '%s', use one of %s", format, strings.Join(OutputFormats, "|"))
+	}
+}
+
+func kubernetesSummary(statuses []ResourceStatus) string {
+	summary := []string{}
+	for _, status := range statuses {
+		summary = append(summary, status.String())
+	}
+	return strings.Join(summary, ", ")
+}
+
+func releasesSummary(releases []ReleaseInfo) string {
+	summary := []string{}
+	for _, rel := range releases {
+		summary = append(summary, fmt.Sprintf("%s/%s:%d", rel.Namespace, rel.Name, rel.Revision))
+	}
+	return strings.Join(summary, ", ")
+}
@@ -0,0 +1,95 @@
+package extension
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"extensionctl/util"
+
+	rspb "helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ReleaseInfo is the latest revision of a helm release
+type ReleaseInfo struct {
+	Name         string    `json:"name"`
+	Namespace    string    `json:"namespace"`
+	Status       string    `json:"status"`
+	Revision     int       `json:"revision"`
+	Chart        string    `json:"chart"`
+	ChartVersion string    `json:"chart_version"`
+	AppVersion   string    `json:"app_version"`
+	LastDeployed time.Time `json:"last_deployed"`
+}
+
+// ListReleases reads the helm release secrets (sh.helm.release.v1.<name>.v<revision>) in all
+// namespaces and returns the latest revision of every release
+func ListReleases(clientset kubernetes.Interface) ([]ReleaseInfo, error) {
+	secrets := driver.NewSecrets(clientset.CoreV1().Secrets(""))
+	releases, err := secrets.List(func(*rspb.Release) bool { return true })
+	if err != nil {
+		return nil, &util.ClusterError{Err: fmt.Errorf("failed to list helm releases: %w", err)}
+	}
+
+	latest := map[string]*rspb.Release{}
+	for _, rel := range releases {
+		key := rel.Namespace + "/" + rel.Name
+		if current, ok := latest[key]; !ok || rel.Version > current.Version {
+			latest[key] = rel
+		}
+	}
+
+	infos := []ReleaseInfo{}
+	for _, rel := range latest {
+		info := ReleaseInfo{Name: rel.Name, Namespace: rel.Namespace, Revision: rel.Version}
+		if rel.Info != nil {
+			info.Status = rel.Info.Status.String()
+			info.LastDeployed = rel.Info.LastDeployed.Time
+		}
+		if rel.Chart != nil && rel.Chart.Metadata != nil {
+			info.Chart = rel.Chart.Metadata.Name
+			info.ChartVersion = rel.Chart.Metadata.Version
+			info.AppVersion = rel.Chart.Metadata.AppVersion
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Name != infos[j].Name {
+			return infos[i].Name < infos[j].Name
+		}
+		return infos[i].Namespace < infos[j].Namespace
+	})
+	return infos, nil
+}
+
+// ReleasesOfChart returns the releases installed from the chart in the given version, multi-installable
+// extensions can have several releases with different names
+func ReleasesOfChart(releases []ReleaseInfo, chartName string, chartVersion string) []ReleaseInfo {
+	found := []ReleaseInfo{}
+	for _, rel := range releases {
+		if rel.Chart == chartName && rel.ChartVersion == chartVersion {
+			found = append(found, rel)
+		}
+	}
+	return found
+}
+
+// summarizeStatus returns the status of the releases, different statuses of multiple releases are joined
+func summarizeStatus(releases []ReleaseInfo) string {
+	if len(releases) == 0 {
+		return StatusNotInstalled
+	}
+	statuses := []string{}
+	seen := map[string]bool{}
+	for _, rel := range releases {
+		if !seen[rel.Status] {
+			seen[rel.Status] = true
+			statuses = append(statuses, rel.Status)
+		}
+	}
+	sort.Strings(statuses)
+	return strings.Join(statuses, ",")
+}
@@ -0,0 +1,27 @@
+package image
+
+import "fmt"
+
+// BuildError is a failure to build, save or tag an image. Image and Dockerfile are empty if the
+// error is not about a single image.
+type BuildError struct {
+	Image      string
+	Dockerfile string
+	Err        error
+}
+
+func (e *BuildError) Error() string {
+	switch {
+	case e.Image != "" && e.Dockerfile != "":
+		return fmt.Sprintf("image %s (%s): %s", e.Image, e.Dockerfile, e.Err)
+	case e.Dockerfile != "":
+		return fmt.Sprintf("image %s: %s", e.Dockerfile, e.Err)
+	case e.Image != "":
+		return fmt.Sprintf("image %s: %s", e.Image, e.Err)
+	}
+	return "images: " + e.Err.Error()
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,143 @@
+package image
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"extensionctl/util"
+)
+
+// baseImageNames returns the names of the images in the FROM lines of a Dockerfile, without
+// registry and tag, e.g. local-only/base-python-cpu:latest -> base-python-cpu
+func baseImageNames(dockerfile string) ([]string, error) {
+	lines, err := readLines(dockerfile)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		ref := ""
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "--") {
+				ref = field
+				break
+			}
+		}
+		if ref == "" {
+			continue
+		}
+		ref = strings.Split(ref, "@")[0]
+		name := ref[strings.LastIndex(ref, "/")+1:]
+		names = append(names, strings.Split(name, ":")[0])
+	}
+	return names, nil
+}
+
+func absPaths(paths []string) ([]string, error) {
+	abs := make([]string, len(paths))
+	for i, path := range paths {
+		var err error
+		abs[i], err = filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return abs, nil
+}
+
+// AffectedDockerfiles returns the Dockerfiles whose build context contains one of the changed
+// files, together with the Dockerfiles built from their images. The result is in build order:
+// images come after the images they are built from, otherwise in the order of dockerfiles.
+func AffectedDockerfiles(dockerfiles []string, changed []string) ([]string, error) {
+	absDockerfiles, err := absPaths(dockerfiles)
+	if err != nil {
+		return nil, err
+	}
+	absChanged, err := absPaths(changed)
+	if err != nil {
+		return nil, err
+	}
+
+	// dependencies[i] are the indexes of the Dockerfiles the image of dockerfiles[i] is built from
+	byName := map[string]int{}
+	for i, dockerfile := range dockerfiles {
+		name, err := getLabelofDockerfile(dockerfile)
+		if err != nil {
+			return nil, err
+		}
+		byName[name] = i
+	}
+	dependencies := make([][]int, len(dockerfiles))
+	dependents := make([][]int, len(dockerfiles))
+	for i, dockerfile := range dockerfiles {
+		bases, err := baseImageNames(dockerfile)
+		if err != nil {
+			return nil, err
+		}
+		for _, base := range bases {
+			if j, ok := byName[base]; ok && j != i {
+				dependencies[i] = append(dependencies[i], j)
+				dependents[j] = append(dependents[j], i)
+			}
+		}
+	}
+
+	affected := make([]bool, len(dockerfiles))
+	queue := []int{}
+	for i, dockerfile := range absDockerfiles {
+		context := filepath.Dir(dockerfile)
+		for _, path := range absChanged {
+			if util.IsInDir(path, context) {
+				affected[i] = true
+				queue = append(queue, i)
+				break
+			}
+		}
+	}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		for _, j := range dependents[i] {
+			if !affected[j] {
+				affected[j] = true
+				queue = append(queue, j)
+			}
+		}
+	}
+
+	ordered := []string{}
+	done := make([]bool, len(dockerfiles))
+	for {
+		progress := false
+		remaining := false
+		for i := range dockerfiles {
+			if !affected[i] || done[i] {
+				continue
+			}
+			ready := true
+			for _, j := range dependencies[i] {
+				if affected[j] && !done[j] {
+					ready = false
+				}
+			}
+			if !ready {
+				remaining = true
+				continue
+			}
+			done[i] = true
+			progress = true
+			ordered = append(ordered, dockerfiles[i])
+		}
+		if !remaining {
+			return ordered, nil
+		}
+		if !progress {
+			return nil, errors.New("the Dockerfiles of the extension are built from each other in a cycle")
+		}
+	}
+}
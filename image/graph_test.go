@@ -0,0 +1,59 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAffectedDockerfiles(t *testing.T) {
+	dir := t.TempDir()
+	dockerfiles := map[string]string{
+		"processing-containers/otsus-method/Dockerfile":  "FROM local-only/base-python-cpu:latest\nLABEL IMAGE=\"otsus-method\"\n",
+		"processing-containers/otsus-report/Dockerfile":  "FROM --platform=linux/amd64 registry.example.com/kaapana/otsus-method:0.1.0 AS base\nLABEL IMAGE=\"otsus-report\"\n",
+		"processing-containers/otsus-summary/Dockerfile": "FROM local-only/otsus-report:latest\nLABEL IMAGE=\"otsus-summary\"\n",
+		"extension/docker/Dockerfile":                    "FROM local-only/base-installer:latest\nLABEL IMAGE=\"dag-otsus-method\"\n",
+	}
+	// in the order of dockerfile_paths, dependents before their base images
+	paths := []string{
+		filepath.Join(dir, "processing-containers/otsus-summary/Dockerfile"),
+		filepath.Join(dir, "processing-containers/otsus-report/Dockerfile"),
+		filepath.Join(dir, "processing-containers/otsus-method/Dockerfile"),
+		filepath.Join(dir, "extension/docker/Dockerfile"),
+	}
+	for name, content := range dockerfiles {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	affected, err := AffectedDockerfiles(paths, []string{filepath.Join(dir, "processing-containers/otsus-method/files/otsus_method.py")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{paths[2], paths[1], paths[0]}
+	if !reflect.DeepEqual(affected, expected) {
+		t.Fatalf("expected %v, got %v", expected, affected)
+	}
+
+	affected, err = AffectedDockerfiles(paths, []string{filepath.Join(dir, "extension/docker/files/dag_otsus_method.py"), filepath.Join(dir, "README.md")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(affected, []string{paths[3]}) {
+		t.Fatalf("expected only the dag image, got %v", affected)
+	}
+
+	affected, err = AffectedDockerfiles(paths, []string{filepath.Join(dir, "processing-containers/otsus-methods.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(affected) != 0 {
+		t.Fatalf("expected no images, got %v", affected)
+	}
+}
@@ -2,20 +2,34 @@ package image
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"extensionctl/util"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 )
 
 func GlobDockerfilePaths(config *util.ExtensionConfig, configPath string) error {
+	dockerfilePaths, err := FindDockerfiles(config.DirPath)
+	if err != nil {
+		return err
+	}
+
+	config.DockerfilePaths = dockerfilePaths
+
+	return util.WriteConfigFile(config, configPath)
+}
+
+// FindDockerfiles returns the Dockerfiles under dirPath
+func FindDockerfiles(dirPath string) ([]string, error) {
 	var dockerfilePaths []string
-	err := filepath.WalkDir(config.DirPath, func(path string, info os.DirEntry, err error) error {
+	err := filepath.WalkDir(dirPath, func(path string, info os.DirEntry, err error) error {
 		if err != nil {
 			color.Red("Encountered error: %s\n", err.Error())
 			return nil
@@ -27,19 +41,29 @@ func GlobDockerfilePaths(config *util.ExtensionConfig, configPath string) error
 		}
 
 		if strings.HasSuffix(path, "Dockerfile") {
-			fmt.Println(path)
+			fmt.Fprintln(color.Output, path)
 			dockerfilePaths = append(dockerfilePaths, path)
 		}
 		return nil
 	})
-	fmt.Printf("dockerfilePaths: %s", dockerfilePaths)
+	fmt.Fprintf(color.Output, "dockerfilePaths: %s\n", dockerfilePaths)
 	if err != nil {
-		color.Red("Encountered error while walking directory: %s\n", err.Error())
+		return nil, &BuildError{Err: fmt.Errorf("failed to search Dockerfiles in %s: %w", dirPath, err)}
 	}
+	return dockerfilePaths, nil
+}
 
-	config.DockerfilePaths = dockerfilePaths
-
-	return util.WriteConfigFile(config, configPath)
+// DockerfilesByImage maps the image names (LABEL IMAGE) of the Dockerfiles to the Dockerfiles
+func DockerfilesByImage(dockerfiles []string) (map[string]string, error) {
+	byImage := map[string]string{}
+	for _, dockerfile := range dockerfiles {
+		imageName, err := getLabelofDockerfile(dockerfile)
+		if err != nil {
+			return nil, &BuildError{Dockerfile: dockerfile, Err: err}
+		}
+		byImage[imageName] = dockerfile
+	}
+	return byImage, nil
 }
 
 func PrioritizePrereqs(prereqDockerfiles []string) ([]string, error) {
@@ -48,8 +72,7 @@ func PrioritizePrereqs(prereqDockerfiles []string) ([]string, error) {
 	for _, dockerfile := range prereqDockerfiles {
 		lines, err := readLines(dockerfile)
 		if err != nil {
-			color.Red(err.Error())
-			return []string{}, err
+			return []string{}, &BuildError{Dockerfile: dockerfile, Err: err}
 		}
 
 		firstLine := strings.TrimSpace(lines[0])
@@ -70,24 +93,25 @@ func FindPrereqDockerfiles(config *util.ExtensionConfig) ([]string, error) {
 	for _, dockerfile := range config.DockerfilePaths {
 		lines, err := readLines(dockerfile)
 		if err != nil {
-			color.Red(err.Error())
-			return nil, err
+			return nil, &BuildError{Dockerfile: dockerfile, Err: err}
 		}
 
 		firstLine := strings.TrimSpace(lines[0])
 		if strings.HasPrefix(firstLine, "FROM local-only/") {
 			imageName, _, err := getImageNameAndTagFromFirstLine(firstLine)
 			if err != nil {
-				color.Red(err.Error())
-				return nil, err
+				return nil, &BuildError{Dockerfile: dockerfile, Err: err}
 			}
-			fmt.Printf("found local prerequisite image %s\n", imageName)
+			fmt.Fprintf(color.Output, "found local prerequisite image %s\n", imageName)
 			dockerfilePaths, err := findDockerfilesInKaapanaPath(imageName, config.KaapanaPath)
 			if err != nil {
-				return nil, err
+				return nil, &BuildError{Dockerfile: dockerfile, Err: err}
 			}
 
 			for _, dockerfilePath := range dockerfilePaths {
+				if !contains(prereqDockerfiles, dockerfilePath) {
+					util.Emit(util.Event{Type: util.EventPrereqFound, Image: imageName, Dockerfile: dockerfilePath})
+				}
 				prereqDockerfiles = appendIfUnique(prereqDockerfiles, dockerfilePath)
 			}
 		}
@@ -99,20 +123,21 @@ func FindPrereqDockerfiles(config *util.ExtensionConfig) ([]string, error) {
 		for _, prereqDockerfile := range prereqDockerfiles {
 			lines, err := readLines(prereqDockerfile)
 			if err != nil {
-				return nil, err
+				return nil, &BuildError{Dockerfile: prereqDockerfile, Err: err}
 			}
 
 			firstLine := strings.TrimSpace(lines[0])
 			if strings.HasPrefix(firstLine, "FROM local-only/") {
 				imageName := getImageNameFromFirstLine(firstLine)
-				fmt.Printf("imageName: %s\n", imageName)
+				fmt.Fprintf(color.Output, "imageName: %s\n", imageName)
 				dockerfilePaths, err := findDockerfilesInKaapanaPath(imageName, config.KaapanaPath)
 				if err != nil {
-					return nil, err
+					return nil, &BuildError{Dockerfile: prereqDockerfile, Err: err}
 				}
 
 				for _, dockerfilePath := range dockerfilePaths {
 					if !contains(prereqDockerfiles, dockerfilePath) && !contains(newPrereqDockerfiles, dockerfilePath) {
+						util.Emit(util.Event{Type: util.EventPrereqFound, Image: imageName, Dockerfile: dockerfilePath})
 						newPrereqDockerfiles = append(newPrereqDockerfiles, dockerfilePath)
 					}
 				}
@@ -158,7 +183,7 @@ func getImageNameFromLabel(line string) string {
 }
 
 func getImageNameFromFirstLine(line string) string {
-	fmt.Printf("getImageNameFromFirstLine %s\n", line)
+	fmt.Fprintf(color.Output, "getImageNameFromFirstLine %s\n", line)
 	split := strings.Split(line, ":")
 
 	if len(split) == 2 {
@@ -202,14 +227,12 @@ func findDockerfilesInKaapanaPath(imageName string, kaapanaPath string) ([]strin
 	err := filepath.Walk(kaapanaPath, func(filePath string, info os.FileInfo, err error) error {
 		// go through all the Dockerfiles inside kaapanaPath
 		if err != nil {
-			color.Red(err.Error())
 			return err
 		}
 
 		if !info.IsDir() && info.Name() == "Dockerfile" {
 			lines, err := readLines(filePath)
 			if err != nil {
-				color.Red(err.Error())
 				return err
 			}
 
@@ -277,39 +300,86 @@ func getLabelofDockerfile(dockerfile string) (string, error) {
 	return res, nil
 }
 
-func BuildDockerImage(dockerfile string, config *util.ExtensionConfig, localOnly bool) (string, error) {
-	color.Blue("building docker image: %s\n", dockerfile)
+// imageTag returns the label and the tag the Dockerfile is built with
+func imageTag(dockerfile string, config *util.ExtensionConfig, localOnly bool) (string, string, error) {
 	imageName, err := getLabelofDockerfile(dockerfile)
 	if err != nil {
-		return "", err
-	}
-	ctxPath := dockerfile
-	suffix := "/Dockerfile"
-	if strings.HasSuffix(ctxPath, suffix) {
-		ctxPath, _ = strings.CutSuffix(ctxPath, suffix)
+		return "", "", err
 	}
 	registry := config.CustomRegistryUrl
 	if localOnly {
 		registry = "local-only"
 	}
-	version := config.KaapanaBuildVersion
+	version := config.Versions.Image
 	if localOnly {
 		version = "latest"
 	}
-	tag := registry + "/" + imageName + ":" + version
-	if imageExists(tag, config.ContainerEngine) && config.NoRebuild {
+	return imageName, registry + "/" + imageName + ":" + version, nil
+}
+
+// ImageTags returns the tags of all images in config.DockerfilePaths without building them
+func ImageTags(config *util.ExtensionConfig) ([]string, error) {
+	tags := []string{}
+	for _, dockerfile := range config.DockerfilePaths {
+		_, tag, err := imageTag(dockerfile, config, false)
+		if err != nil {
+			return nil, &BuildError{Dockerfile: dockerfile, Err: err}
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+func BuildDockerImage(ctx context.Context, dockerfile string, config *util.ExtensionConfig, localOnly bool) (string, error) {
+	color.Blue("building docker image: %s\n", dockerfile)
+	imageName, tag, err := imageTag(dockerfile, config, localOnly)
+	if err != nil {
+		return "", &BuildError{Dockerfile: dockerfile, Err: err}
+	}
+	ctxPath := dockerfile
+	suffix := "/Dockerfile"
+	if strings.HasSuffix(ctxPath, suffix) {
+		ctxPath, _ = strings.CutSuffix(ctxPath, suffix)
+	}
+	if imageExists(ctx, tag, config.ContainerEngine) && config.NoRebuild {
 		color.Yellow("image %s already exists, not building since no_rebuild==true", tag)
-		return imageName, nil
+		event := util.Event{Type: util.EventBuildFinished, Image: tag, Dockerfile: dockerfile, Status: util.BuildStatusSkipped}
+		if util.EventsEnabled() {
+			if info, err := inspectImage(ctx, tag, config.ContainerEngine); err == nil {
+				event.Bytes, event.Layers = info.Size, info.Layers
+			}
+		}
+		util.Emit(event)
+		return tag, nil
 	}
 	color.Blue("imageName %s, tag %s\n", imageName, tag)
-	command := exec.Command(config.ContainerEngine, "build", "-t", tag, ctxPath)
-	command.Stdout = os.Stdout
+	util.Emit(util.Event{Type: util.EventBuildStarted, Image: tag, Dockerfile: dockerfile})
+	var previous imageInfo
+	if util.EventsEnabled() {
+		// a build that results in the same image only used the cache
+		previous, _ = inspectImage(ctx, tag, config.ContainerEngine)
+	}
+	start := time.Now()
+	command := util.Command(ctx, config.ContainerEngine, "build", "-t", tag, ctxPath)
+	command.Stdout = color.Output
 	command.Stderr = os.Stderr
 
 	err = command.Run()
 	if err != nil {
-		return "", errors.New("failed to build Docker image: " + err.Error())
+		err = &BuildError{Image: tag, Dockerfile: dockerfile, Err: fmt.Errorf("%s build failed: %w", config.ContainerEngine, util.ContextError(ctx, err))}
+		util.Emit(util.Event{Type: util.EventBuildFinished, Image: tag, Dockerfile: dockerfile, Status: util.BuildStatusFailed, DurationMS: util.Since(start), Message: err.Error()})
+		return "", err
+	}
+	event := util.Event{Type: util.EventBuildFinished, Image: tag, Dockerfile: dockerfile, Status: util.BuildStatusBuilt, DurationMS: util.Since(start)}
+	if util.EventsEnabled() {
+		if info, err := inspectImage(ctx, tag, config.ContainerEngine); err == nil {
+			event.Bytes, event.Layers = info.Size, info.Layers
+			if previous.ID != "" && previous.ID == info.ID {
+				event.Status = util.BuildStatusCached
+			}
+		}
 	}
+	util.Emit(event)
 
 	color.Magenta("successfully built %s in path %s\n", tag, dockerfile)
 
@@ -324,76 +394,195 @@ func getFirstLine(filepath string) string {
 	return lines[0]
 }
 
-func SaveImages(imageNames []string, dirPath string, containerEngine string) error {
+// SaveImages saves the images into one archive, an archive that was not completely written
+// because ctx is done is removed
+func SaveImages(ctx context.Context, imageNames []string, savePath string, containerEngine string) error {
 	// save
-	savePath := filepath.Join(dirPath, "images.tar")
 	cmd := []string{"save", "-o", savePath}
 	for _, imageName := range imageNames {
 		cmd = append(cmd, imageName)
 	}
 	color.Blue("saving images %s into %s...\n", imageNames, savePath)
-	command := exec.Command(containerEngine, cmd...)
-	command.Stdout = os.Stdout
+	util.Emit(util.Event{Type: util.EventSaveStarted, File: savePath, Message: strings.Join(imageNames, " ")})
+	start := time.Now()
+	command := util.Command(ctx, containerEngine, cmd...)
+	command.Stdout = color.Output
 	command.Stderr = os.Stderr
 
+	done := make(chan struct{})
+	if util.EventsEnabled() {
+		go reportSaveProgress(savePath, done)
+	}
 	err := command.Run()
+	close(done)
 	if err != nil {
-		color.Red("failed to save Docker images: %s", err.Error())
-		return errors.New("failed to save Docker images: " + err.Error())
+		if ctx.Err() != nil {
+			os.Remove(savePath)
+		}
+		return &BuildError{Err: fmt.Errorf("failed to save images into %s: %w", savePath, util.ContextError(ctx, err))}
 	}
 
+	event := util.Event{Type: util.EventSaveFinished, File: savePath, DurationMS: util.Since(start)}
+	if info, err := os.Stat(savePath); err == nil {
+		event.Bytes = info.Size()
+	}
+	util.Emit(event)
 	return nil
 }
 
-func ChangeImageRefs(dirPath string, query string, newValue string) error {
+// saveProgressInterval is how often the size of the archive is reported while it is saved
+var saveProgressInterval = time.Second
+
+// reportSaveProgress emits the size of the archive until done is closed, the engines don't
+// report how much of the images is written
+func reportSaveProgress(savePath string, done chan struct{}) {
+	ticker := time.NewTicker(saveProgressInterval)
+	defer ticker.Stop()
+	var reported int64
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(savePath)
+			if err != nil || info.Size() == reported {
+				continue
+			}
+			reported = info.Size()
+			util.Emit(util.Event{Type: util.EventSaveProgress, File: savePath, Bytes: reported})
+		}
+	}
+}
+
+// FileChanges keeps the original content of the files changed by ChangeImageRefs, so that
+// they can be restored if the build is interrupted. Files changed by several calls keep the
+// content from before the first change.
+type FileChanges struct {
+	originals map[string][]byte
+}
+
+func NewFileChanges() *FileChanges {
+	return &FileChanges{originals: map[string][]byte{}}
+}
+
+func (changes *FileChanges) record(path string, original []byte) {
+	if _, ok := changes.originals[path]; !ok {
+		changes.originals[path] = original
+	}
+}
+
+// Restore writes the original content back into the changed files
+func (changes *FileChanges) Restore() {
+	restoreFiles(changes.originals)
+	changes.originals = map[string][]byte{}
+}
+
+// ChangeImageRefs replaces query with newValue in the .py files below dirPath and records the
+// original content in changes. If ctx is done before all files are changed, it stops and the
+// caller is expected to restore the changes.
+func ChangeImageRefs(ctx context.Context, changes *FileChanges, dirPath string, query string, newValue string) error {
 	color.Blue("Changing image references in .py files")
+	failed := []string{}
 	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			color.Red("Error accessing path %s: %v\n", path, err)
+			return err
+		}
+		if err := ctx.Err(); err != nil {
 			return err
 		}
 
 		if !info.IsDir() && strings.HasSuffix(path, ".py") {
 			color.Magenta("file: %s , changing '%s' to '%s'", path, query, newValue)
-			err := searchAndReplace(path, query, newValue)
+			original, err := searchAndReplace(path, query, newValue)
 			if err != nil {
-				color.Red("Error searching and replacing in file %s: %v\n", path, err)
+				// continue with the other files, so that all of them are listed
+				color.Red("- %s", err.Error())
+				failed = append(failed, path)
+			} else if original != nil {
+				changes.record(path, original)
 			}
-			return nil
 		}
 
 		return nil
 	})
 
+	if ctx.Err() != nil {
+		return &BuildError{Err: fmt.Errorf("changing image references in %s was interrupted: %w", dirPath, ctx.Err())}
+	}
 	if err != nil {
-		color.Red("Error walking through directory: %v\n", err)
-		return err
+		return &BuildError{Err: fmt.Errorf("failed to change image references in %s: %w", dirPath, err)}
+	}
+	if len(failed) > 0 {
+		return &BuildError{Err: fmt.Errorf("failed to change image references in %d files listed above", len(failed))}
 	}
 	return nil
 }
 
-func searchAndReplace(file string, query string, newValue string) error {
+// searchAndReplace only writes files that contain the query, it returns the original content
+// of a changed file
+func searchAndReplace(file string, query string, newValue string) ([]byte, error) {
 	content, err := os.ReadFile(file)
 	if err != nil {
-		color.Red(err.Error())
-		return err
+		return nil, err
 	}
 
-	if strings.Contains(string(content), query) {
-		fmt.Printf("Changing %s to %s in file %s\n", query, newValue, file)
+	if !strings.Contains(string(content), query) {
+		return nil, nil
 	}
+	fmt.Fprintf(color.Output, "Changing %s to %s in file %s\n", query, newValue, file)
 	newContent := strings.ReplaceAll(string(content), query, newValue)
-	err = os.WriteFile(file, []byte(newContent), 0)
+	if err := util.WriteFileAtomic(file, []byte(newContent), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", file, err)
+	}
+
+	return content, nil
+}
+
+func restoreFiles(originals map[string][]byte) {
+	for path, content := range originals {
+		if err := util.WriteFileAtomic(path, content, 0644); err != nil {
+			color.Red("failed to restore %s: %s", path, err.Error())
+			continue
+		}
+		color.Yellow("restored %s", path)
+	}
+}
+
+// imageInfo is the part of 'image inspect' that is shown in the build report
+type imageInfo struct {
+	ID     string
+	Size   int64
+	Layers int
+}
+
+func inspectImage(ctx context.Context, image string, containerEngine string) (imageInfo, error) {
+	var info imageInfo
+	out, err := util.Command(ctx, containerEngine, "image", "inspect", "--format", "{{.Id}} {{.Size}} {{len .RootFS.Layers}}", image).Output()
 	if err != nil {
-		color.Red(err.Error())
-		return err
+		return info, fmt.Errorf("failed to inspect image %s: %w", image, err)
 	}
+	return parseImageInfo(string(out))
+}
 
-	return nil
+func parseImageInfo(out string) (imageInfo, error) {
+	var info imageInfo
+	fields := strings.Fields(out)
+	if len(fields) != 3 {
+		return info, fmt.Errorf("unexpected output of image inspect: %q", out)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return info, fmt.Errorf("unexpected size in the output of image inspect: %w", err)
+	}
+	layers, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return info, fmt.Errorf("unexpected number of layers in the output of image inspect: %w", err)
+	}
+	return imageInfo{ID: fields[0], Size: size, Layers: layers}, nil
 }
 
-func imageExists(image string, containerEngine string) bool {
-	out, err := exec.Command(containerEngine, "images", image).Output()
+func imageExists(ctx context.Context, image string, containerEngine string) bool {
+	out, err := util.Command(ctx, containerEngine, "images", image).Output()
 	if err != nil {
 		color.Red(err.Error())
 	}
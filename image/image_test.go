@@ -0,0 +1,95 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChangeImageRefs(t *testing.T) {
+	dir := t.TempDir()
+	operator := filepath.Join(dir, "operator.py")
+	if err := os.WriteFile(operator, []byte("image=f\"{DEFAULT_REGISTRY}/otsus-method:0.1.0\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(dir, "other.py")
+	if err := os.WriteFile(other, []byte("print('hello')\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(other, old, old); err != nil {
+		t.Fatal(err)
+	}
+	// a .py path that can't be read
+	if err := os.Symlink(t.TempDir(), filepath.Join(dir, "broken.py")); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ChangeImageRefs(context.Background(), NewFileChanges(), dir, "{DEFAULT_REGISTRY}", "registry.example.com")
+	var buildErr *BuildError
+	if !errors.As(err, &buildErr) {
+		t.Fatalf("expected a BuildError for broken.py, got %v", err)
+	}
+	content, err := os.ReadFile(operator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "image=f\"registry.example.com/otsus-method:0.1.0\"\n" {
+		t.Fatalf("image reference was not changed, got %q", content)
+	}
+	if info, err := os.Stat(other); err != nil || !info.ModTime().Equal(old) {
+		t.Fatalf("file without the query should not be written")
+	}
+}
+
+// interruptedContext is canceled after Err was called n times
+type interruptedContext struct {
+	context.Context
+	n int
+}
+
+func (c *interruptedContext) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestChangeImageRefsInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	original := []byte("image=f\"{DEFAULT_REGISTRY}/otsus-method:{KAAPANA_BUILD_VERSION}\"\n")
+	for _, name := range []string{"a.py", "b.py"} {
+		if err := os.WriteFile(filepath.Join(dir, name), original, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first pass changes both files, the second one is canceled at b.py after a.py was changed again
+	changes := NewFileChanges()
+	if err := ChangeImageRefs(context.Background(), changes, dir, "{DEFAULT_REGISTRY}", "registry.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := &interruptedContext{Context: context.Background(), n: 2}
+	err := ChangeImageRefs(ctx, changes, dir, "{KAAPANA_BUILD_VERSION}", "0.1.0")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the change to be canceled, got %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "a.py")); string(content) != "image=f\"registry.example.com/otsus-method:0.1.0\"\n" {
+		t.Fatalf("a.py was not changed by both passes, got %q", content)
+	}
+
+	changes.Restore()
+	for _, name := range []string{"a.py", "b.py"} {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != string(original) {
+			t.Fatalf("%s was not restored, got %q", name, content)
+		}
+	}
+}
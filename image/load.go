@@ -0,0 +1,141 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"extensionctl/util"
+
+	"github.com/fatih/color"
+)
+
+// LoadTargets are the local cluster runtimes the saved images can be imported into
+var LoadTargets = []string{"microk8s", "k3s", "kind", "containerd"}
+
+// ctrCommand returns the ctr command of the runtime in the k8s.io namespace, which is the
+// containerd namespace kubelet pulls images from
+func ctrCommand(target string) ([]string, error) {
+	switch target {
+	case "microk8s":
+		return []string{"microk8s", "ctr", "--namespace", "k8s.io"}, nil
+	case "k3s":
+		return []string{"k3s", "ctr", "--namespace", "k8s.io"}, nil
+	case "containerd":
+		return []string{"ctr", "--namespace", "k8s.io"}, nil
+	}
+	return nil, fmt.Errorf("unknown target '%s' for --load-into, use one of %s", target, strings.Join(LoadTargets, "|"))
+}
+
+func ValidateLoadTarget(target string) error {
+	if target == "kind" {
+		return nil
+	}
+	_, err := ctrCommand(target)
+	return err
+}
+
+func runCommand(ctx context.Context, args []string, stdin *os.File) ([]byte, error) {
+	command := util.Command(ctx, args[0], args[1:]...)
+	if stdin != nil {
+		command.Stdin = stdin
+	}
+	var stderr bytes.Buffer
+	command.Stderr = &stderr
+	out, err := command.Output()
+	if err != nil {
+		return out, fmt.Errorf("'%s' failed: %w %s", strings.Join(args, " "), util.ContextError(ctx, err), strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// importArchive streams the archive into ctr instead of passing the path, since snap confined
+// microk8s can't read files outside of the home directory
+func importArchive(ctx context.Context, tarPath string, target string, kindCluster string) error {
+	if target == "kind" {
+		_, err := runCommand(ctx, []string{"kind", "load", "image-archive", tarPath, "--name", kindCluster}, nil)
+		return err
+	}
+	ctr, err := ctrCommand(target)
+	if err != nil {
+		return err
+	}
+	archive, err := os.Open(tarPath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	_, err = runCommand(ctx, append(ctr, "images", "import", "-"), archive)
+	return err
+}
+
+// listImages returns the output of 'ctr images ls -q' of every node
+func listImages(ctx context.Context, target string, kindCluster string) ([]string, error) {
+	if target != "kind" {
+		ctr, err := ctrCommand(target)
+		if err != nil {
+			return nil, err
+		}
+		out, err := runCommand(ctx, append(ctr, "images", "ls", "-q"), nil)
+		if err != nil {
+			return nil, err
+		}
+		return []string{string(out)}, nil
+	}
+
+	out, err := runCommand(ctx, []string{"kind", "get", "nodes", "--name", kindCluster}, nil)
+	if err != nil {
+		return nil, err
+	}
+	listed := []string{}
+	for _, node := range strings.Fields(string(out)) {
+		images, err := runCommand(ctx, []string{"docker", "exec", node, "ctr", "--namespace", "k8s.io", "images", "ls", "-q"}, nil)
+		if err != nil {
+			return nil, err
+		}
+		listed = append(listed, string(images))
+	}
+	return listed, nil
+}
+
+// missingImages returns the images that are not in the 'ctr images ls -q' output
+func missingImages(listOutput string, images []string) []string {
+	available := map[string]bool{}
+	for _, ref := range strings.Fields(listOutput) {
+		available[NormalizeImageRef(ref)] = true
+	}
+	missing := []string{}
+	for _, img := range images {
+		if !available[NormalizeImageRef(img)] {
+			missing = append(missing, img)
+		}
+	}
+	return missing
+}
+
+// LoadImages imports the saved images into the containerd of the local cluster and checks that
+// the tags are visible to kubelet, so that pods with imagePullPolicy IfNotPresent use them
+func LoadImages(ctx context.Context, tarPath string, images []string, target string, kindCluster string) error {
+	color.Blue("importing %s into %s...", tarPath, target)
+	if err := importArchive(ctx, tarPath, target, kindCluster); err != nil {
+		return &util.ClusterError{Err: fmt.Errorf("failed to import images: %w", err)}
+	}
+
+	listed, err := listImages(ctx, target, kindCluster)
+	if err != nil {
+		return &util.ClusterError{Err: fmt.Errorf("failed to list the images of %s: %w", target, err)}
+	}
+	for _, nodeImages := range listed {
+		if missing := missingImages(nodeImages, images); len(missing) > 0 {
+			for _, img := range missing {
+				color.Red("- %s", img)
+			}
+			return &util.ClusterError{Err: errors.New("images listed above are not available in " + target + " after the import")}
+		}
+	}
+	color.Blue("imported %d images into %s", len(images), target)
+	return nil
+}
@@ -0,0 +1,34 @@
+package image
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMissingImages(t *testing.T) {
+	listOutput := `docker.io/kaapana/otsus-method:0.1.0
+registry.example.com/kaapana/otsus-method-service:0.1.0
+sha256:4a2d1c1c0e0b7c4f2d5f0c6e0f3a6b7f5f8c9d0e1a2b3c4d5e6f7a8b9c0d1e2f
+`
+	images := []string{
+		"kaapana/otsus-method:0.1.0",
+		"registry.example.com/kaapana/otsus-method-service:0.1.0",
+		"registry.example.com/kaapana/otsus-method-dag:0.1.0",
+	}
+	missing := missingImages(listOutput, images)
+	expected := []string{"registry.example.com/kaapana/otsus-method-dag:0.1.0"}
+	if !reflect.DeepEqual(missing, expected) {
+		t.Fatalf("expected %v, got %v", expected, missing)
+	}
+}
+
+func TestValidateLoadTarget(t *testing.T) {
+	for _, target := range LoadTargets {
+		if err := ValidateLoadTarget(target); err != nil {
+			t.Fatalf("target %s should be valid: %v", target, err)
+		}
+	}
+	if err := ValidateLoadTarget("minikube"); err == nil {
+		t.Fatalf("expected an error for an unknown target")
+	}
+}
@@ -0,0 +1,143 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"extensionctl/util"
+
+	"github.com/fatih/color"
+	"helm.sh/helm/v3/pkg/provenance"
+)
+
+// DigestManifest describes a saved image archive, it is signed instead of the
+// (large) archive itself
+type DigestManifest struct {
+	Archive string                `json:"archive"`
+	Size    int64                 `json:"size"`
+	SHA256  string                `json:"sha256"`
+	Images  []DigestManifestImage `json:"images"`
+}
+
+type DigestManifestImage struct {
+	Tags   []string `json:"tags"`
+	Config string   `json:"config"`
+	Layers []string `json:"layers"`
+}
+
+func DigestManifestPath(tarPath string) string {
+	return tarPath + ".digests.json"
+}
+
+func archiveImages(tarPath string) ([]DigestManifestImage, error) {
+	file, err := os.Open(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tarReader := tar.NewReader(file)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", tarPath, err)
+		}
+		if header.Name != "manifest.json" {
+			continue
+		}
+
+		var manifest []struct {
+			Config   string   `json:"Config"`
+			RepoTags []string `json:"RepoTags"`
+			Layers   []string `json:"Layers"`
+		}
+		if err := json.NewDecoder(tarReader).Decode(&manifest); err != nil {
+			return nil, fmt.Errorf("failed to parse manifest.json in %s: %w", tarPath, err)
+		}
+		images := []DigestManifestImage{}
+		for _, m := range manifest {
+			images = append(images, DigestManifestImage{Tags: m.RepoTags, Config: m.Config, Layers: m.Layers})
+		}
+		return images, nil
+	}
+	// oci archives don't have a manifest.json, the checksum of the archive still covers them
+	return []DigestManifestImage{}, nil
+}
+
+// WriteDigestManifest writes the sha256 of the archive and the config and layer
+// digests of every image in it to <archive>.digests.json
+func WriteDigestManifest(tarPath string) (string, error) {
+	artifact, err := util.NewArtifact("images", tarPath)
+	if err != nil {
+		return "", err
+	}
+	images, err := archiveImages(tarPath)
+	if err != nil {
+		return "", err
+	}
+
+	manifest := DigestManifest{
+		Archive: filepath.Base(tarPath),
+		Size:    artifact.Size,
+		SHA256:  artifact.SHA256,
+		Images:  images,
+	}
+	content, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	manifestPath := DigestManifestPath(tarPath)
+	if err := os.WriteFile(manifestPath, content, 0644); err != nil {
+		return "", err
+	}
+	color.Blue("wrote digest manifest %s", manifestPath)
+	return manifestPath, nil
+}
+
+// SignImages writes the digest manifest of the archive and its detached signature
+func SignImages(tarPath string, signer *provenance.Signatory) (string, string, error) {
+	manifestPath, err := WriteDigestManifest(tarPath)
+	if err != nil {
+		return "", "", err
+	}
+	sigPath, err := util.SignFileDetached(signer, manifestPath)
+	if err != nil {
+		return "", "", err
+	}
+	return manifestPath, sigPath, nil
+}
+
+// VerifyImages checks the signature of the digest manifest against keyring and the
+// archive against the manifest. It returns the identity of the signer.
+func VerifyImages(tarPath string, keyring string) (string, error) {
+	manifestPath := DigestManifestPath(tarPath)
+	signer, err := util.VerifyFileDetached(keyring, manifestPath, manifestPath+".asc")
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return "", err
+	}
+	var manifest DigestManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", manifestPath, err)
+	}
+	artifact, err := util.NewArtifact("images", tarPath)
+	if err != nil {
+		return "", err
+	}
+	if artifact.SHA256 != manifest.SHA256 || artifact.Size != manifest.Size {
+		return "", errors.New("checksum of " + tarPath + " does not match the signed digest manifest " + manifestPath)
+	}
+	return signer, nil
+}
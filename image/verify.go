@@ -0,0 +1,201 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// VerifyReport is the result of cross-checking the images a chart refers to
+// against the built images and the saved archive
+type VerifyReport struct {
+	// referenced by the chart in the registry of the extension, but not built
+	Missing []string `json:"missing"`
+	// built, but not referenced by the chart
+	Unused []string `json:"unused"`
+	// built, but not contained in the saved archive
+	NotArchived []string `json:"not_archived"`
+	// referenced by the chart outside of the registry of the extension or a platform image
+	External []string `json:"external"`
+}
+
+func (r *VerifyReport) Failed() bool {
+	return len(r.Missing) > 0 || len(r.NotArchived) > 0
+}
+
+// NormalizeImageRef adds the default registry, namespace and tag the same way
+// docker does, so that 'kaapana/x' and 'docker.io/kaapana/x:latest' are equal
+func NormalizeImageRef(ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ref
+	}
+	name, digest, hasDigest := strings.Cut(ref, "@")
+
+	parts := strings.Split(name, "/")
+	if len(parts) == 1 {
+		parts = []string{"docker.io", "library", parts[0]}
+	} else if !strings.ContainsAny(parts[0], ".:") && parts[0] != "localhost" {
+		parts = append([]string{"docker.io"}, parts...)
+	}
+	last := parts[len(parts)-1]
+	if !hasDigest && !strings.Contains(last, ":") {
+		parts[len(parts)-1] = last + ":latest"
+	}
+	name = strings.Join(parts, "/")
+	if hasDigest {
+		return name + "@" + digest
+	}
+	return name
+}
+
+// imageName returns the normalized reference without tag and digest
+func imageName(ref string) string {
+	name, _, _ := strings.Cut(NormalizeImageRef(ref), "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	return name
+}
+
+// ArchivedImages returns the image tags contained in an archive created by 'docker save' or 'podman save'
+func ArchivedImages(tarPath string) ([]string, error) {
+	file, err := os.Open(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tags := []string{}
+	tarReader := tar.NewReader(file)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", tarPath, err)
+		}
+
+		switch header.Name {
+		case "manifest.json":
+			// docker-archive format
+			var manifest []struct {
+				RepoTags []string `json:"RepoTags"`
+			}
+			if err := json.NewDecoder(tarReader).Decode(&manifest); err != nil {
+				return nil, fmt.Errorf("failed to parse manifest.json in %s: %w", tarPath, err)
+			}
+			for _, m := range manifest {
+				for _, tag := range m.RepoTags {
+					tags = appendIfUnique(tags, tag)
+				}
+			}
+		case "index.json":
+			// oci-archive format
+			var index struct {
+				Manifests []struct {
+					Annotations map[string]string `json:"annotations"`
+				} `json:"manifests"`
+			}
+			if err := json.NewDecoder(tarReader).Decode(&index); err != nil {
+				return nil, fmt.Errorf("failed to parse index.json in %s: %w", tarPath, err)
+			}
+			for _, m := range index.Manifests {
+				if name, ok := m.Annotations["io.containerd.image.name"]; ok {
+					tags = appendIfUnique(tags, name)
+				}
+			}
+		}
+	}
+
+	if len(tags) == 0 {
+		return nil, errors.New("no tagged images found in " + tarPath)
+	}
+	return tags, nil
+}
+
+// DefaultPlatformImages are provided by the platform in the registry of the extension and
+// therefore don't have to be built, e.g. the base images of processing containers
+var DefaultPlatformImages = []string{"base-python-cpu", "base-python-gpu"}
+
+// CrossCheckImages compares the images referenced by the chart with the built and archived ones.
+// archived is nil if no archive was saved. Referenced images in registry are expected to be built,
+// unless their last path component is listed in platformImages.
+func CrossCheckImages(referenced []string, built []string, archived []string, registry string, platformImages []string) *VerifyReport {
+	report := &VerifyReport{Missing: []string{}, Unused: []string{}, NotArchived: []string{}, External: []string{}}
+
+	builtSet := map[string]bool{}
+	for _, tag := range built {
+		builtSet[NormalizeImageRef(tag)] = true
+	}
+	referencedSet := map[string]bool{}
+	for _, ref := range referenced {
+		referencedSet[NormalizeImageRef(ref)] = true
+	}
+	archivedSet := map[string]bool{}
+	for _, tag := range archived {
+		archivedSet[NormalizeImageRef(tag)] = true
+	}
+	platformSet := map[string]bool{}
+	for _, name := range platformImages {
+		platformSet[name] = true
+	}
+	// normalized the same way as the references, e.g. 'kaapana' becomes 'docker.io/kaapana/'
+	registryPrefix := strings.TrimSuffix(NormalizeImageRef(strings.TrimSuffix(registry, "/")+"/image:tag"), "image:tag")
+
+	for _, ref := range referenced {
+		normalized := NormalizeImageRef(ref)
+		if builtSet[normalized] || archivedSet[normalized] {
+			continue
+		}
+		name := imageName(ref)
+		name = name[strings.LastIndex(name, "/")+1:]
+		if registry != "" && strings.HasPrefix(normalized, registryPrefix) && !platformSet[name] {
+			report.Missing = appendIfUnique(report.Missing, ref)
+		} else {
+			report.External = appendIfUnique(report.External, ref)
+		}
+	}
+	for _, tag := range built {
+		normalized := NormalizeImageRef(tag)
+		if !referencedSet[normalized] {
+			report.Unused = appendIfUnique(report.Unused, tag)
+		}
+		if archived != nil && !archivedSet[normalized] {
+			report.NotArchived = appendIfUnique(report.NotArchived, tag)
+		}
+	}
+
+	sort.Strings(report.Missing)
+	sort.Strings(report.Unused)
+	sort.Strings(report.NotArchived)
+	sort.Strings(report.External)
+	return report
+}
+
+func (r *VerifyReport) Print() {
+	printList := func(title string, images []string, print func(format string, a ...interface{})) {
+		if len(images) == 0 {
+			return
+		}
+		print(title)
+		for _, image := range images {
+			print("- %s", image)
+		}
+	}
+	printList("images in the registry of the extension referenced by the chart, but not built:", r.Missing, color.Red)
+	printList("built images missing in the saved archive:", r.NotArchived, color.Red)
+	printList("built images not referenced by the chart (e.g. only used in DAGs):", r.Unused, color.Yellow)
+	printList("images not built by the extension, expected to be available on the platform:", r.External, color.Blue)
+	if !r.Failed() {
+		color.Green("all images referenced by the chart were built and saved")
+	}
+}
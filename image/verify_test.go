@@ -0,0 +1,43 @@
+package image
+
+import (
+	"testing"
+)
+
+func TestNormalizeImageRef(t *testing.T) {
+	cases := map[string]string{
+		"busybox":                          "docker.io/library/busybox:latest",
+		"kaapana/otsus-method:0.2.2":       "docker.io/kaapana/otsus-method:0.2.2",
+		"registry.example.com:5000/a/b":    "registry.example.com:5000/a/b:latest",
+		"localhost/local-only/base:latest": "localhost/local-only/base:latest",
+	}
+	for ref, expected := range cases {
+		if normalized := NormalizeImageRef(ref); normalized != expected {
+			t.Errorf("expected %s to be normalized to %s, got %s", ref, expected, normalized)
+		}
+	}
+}
+
+func TestCrossCheckImages(t *testing.T) {
+	// kaapana/base-python-cpu is a platform image in the same registry as the extension images
+	referenced := []string{"docker.io/kaapana/dag:0.2.2", "kaapana/dag:0.2.1", "kaapana/typo:0.2.2", "kaapana/base-python-cpu:0.2.2", "busybox:1.36"}
+	built := []string{"docker.io/kaapana/dag:0.2.2", "docker.io/kaapana/processing:0.2.2"}
+	archived := []string{"kaapana/dag:0.2.2"}
+
+	report := CrossCheckImages(referenced, built, archived, "docker.io/kaapana", DefaultPlatformImages)
+	if len(report.Missing) != 2 || report.Missing[0] != "kaapana/dag:0.2.1" || report.Missing[1] != "kaapana/typo:0.2.2" {
+		t.Errorf("unexpected missing images %s", report.Missing)
+	}
+	if len(report.Unused) != 1 || report.Unused[0] != "docker.io/kaapana/processing:0.2.2" {
+		t.Errorf("unexpected unused images %s", report.Unused)
+	}
+	if len(report.NotArchived) != 1 || report.NotArchived[0] != "docker.io/kaapana/processing:0.2.2" {
+		t.Errorf("unexpected not archived images %s", report.NotArchived)
+	}
+	if len(report.External) != 2 || report.External[0] != "busybox:1.36" || report.External[1] != "kaapana/base-python-cpu:0.2.2" {
+		t.Errorf("unexpected external images %s", report.External)
+	}
+	if !report.Failed() {
+		t.Errorf("expected the report to fail")
+	}
+}
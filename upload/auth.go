@@ -0,0 +1,109 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TokenEnv is read if no token is given on the command line
+const TokenEnv = "EXTENSIONCTL_TOKEN"
+
+// OIDCOptions request a token from the identity provider of the platform (Keycloak realm
+// kaapana). With a username the password grant is used, otherwise client credentials.
+type OIDCOptions struct {
+	// Issuer is the url of the realm, e.g. https://kaapana.example.com/auth/realms/kaapana
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Username     string
+	Password     string
+}
+
+func (c *Client) tokenEndpoint(ctx context.Context) (string, error) {
+	discoveryURL := strings.TrimSuffix(c.opts.OIDC.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the openid configuration of %s: %w", c.opts.OIDC.Issuer, err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return "", fmt.Errorf("failed to get the openid configuration of %s: %w", c.opts.OIDC.Issuer, err)
+	}
+	var configuration struct {
+		TokenEndpoint string `json:"token_endpoint"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&configuration); err != nil {
+		return "", fmt.Errorf("failed to parse the openid configuration of %s: %w", c.opts.OIDC.Issuer, err)
+	}
+	if configuration.TokenEndpoint == "" {
+		return "", errors.New("openid configuration of " + c.opts.OIDC.Issuer + " has no token_endpoint")
+	}
+	return configuration.TokenEndpoint, nil
+}
+
+// requestToken gets a new access token from the identity provider
+func (c *Client) requestToken(ctx context.Context) (string, error) {
+	endpoint, err := c.tokenEndpoint(ctx)
+	if err != nil {
+		return "", err
+	}
+	oidc := c.opts.OIDC
+	form := url.Values{"client_id": {oidc.ClientID}}
+	if oidc.ClientSecret != "" {
+		form.Set("client_secret", oidc.ClientSecret)
+	}
+	if oidc.Username != "" {
+		form.Set("grant_type", "password")
+		form.Set("username", oidc.Username)
+		form.Set("password", oidc.Password)
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request a token from %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return "", fmt.Errorf("failed to request a token from %s: %w", endpoint, err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to parse the token response of %s: %w", endpoint, err)
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("token response of " + endpoint + " has no access_token")
+	}
+	return token.AccessToken, nil
+}
+
+// checkResponse returns an error with the status and the start of the body for non 2xx responses
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return fmt.Errorf("%s: %s", resp.Status, message)
+}
@@ -0,0 +1,497 @@
+// Package upload sends charts and image archives to the extension upload endpoints of the kube-helm
+// backend of the platform, the same endpoints the upload dialog of the extensions page uses:
+//
+//	POST /file                 chart tgz as multipart field 'file'
+//	POST /file_chunks_init     {"name", "fileSize", "chunkSize", "index", "endIndex"}, the response
+//	                           may contain {"index"} to continue at the chunk the server expects
+//	POST /file_chunks          multipart fields 'index' and 'file' with the content of one chunk
+//	POST /import-container     {"filename"}, imports an uploaded image archive into containerd
+//	GET  /extensions           list of extensions with 'name', 'version' and 'available_versions'
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"extensionctl/bundle"
+
+	"github.com/fatih/color"
+	"helm.sh/helm/v3/pkg/chart/loader"
+)
+
+const (
+	DefaultChunkSize    = 10 << 20
+	DefaultPollInterval = 5 * time.Second
+	DefaultTimeout      = 10 * time.Minute
+	// chunkRetries is how often a failed chunk is sent again before the upload is aborted
+	chunkRetries = 3
+)
+
+// retryDelay is multiplied with the attempt before a failed chunk is sent again
+var retryDelay = time.Second
+
+type Options struct {
+	// URL of the kube-helm api, e.g. https://kaapana.example.com/kube-helm-api
+	URL string
+	// Token is sent as bearer token, if empty and OIDC.Issuer is set a token is requested
+	Token                 string
+	OIDC                  OIDCOptions
+	ChunkSize             int64
+	InsecureSkipTLSVerify bool
+	// StateDir keeps the progress of chunked uploads, so that an interrupted upload continues
+	// where it stopped (default <user cache dir>/extensionctl/uploads)
+	StateDir     string
+	PollInterval time.Duration
+	// Timeout is how long to wait until the platform lists an uploaded extension
+	Timeout time.Duration
+	// Progress receives the progress of chunked uploads, nil disables it
+	Progress io.Writer
+}
+
+type Client struct {
+	opts       Options
+	httpClient *http.Client
+	token      string
+}
+
+func NewClient(ctx context.Context, opts Options) (*Client, error) {
+	if opts.URL == "" {
+		return nil, errors.New("url of the kube-helm api is empty")
+	}
+	opts.URL = strings.TrimSuffix(opts.URL, "/")
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.StateDir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		opts.StateDir = filepath.Join(cacheDir, "extensionctl", "uploads")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.InsecureSkipTLSVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+	}
+	c := &Client{opts: opts, httpClient: &http.Client{Transport: transport}, token: opts.Token}
+	if c.token == "" && opts.OIDC.Issuer != "" {
+		token, err := c.requestToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.token = token
+	}
+	return c, nil
+}
+
+// do sends the request built by newRequest. Tokens of the platform are short-lived, so on 401 a
+// new token is requested once and the request is sent again.
+func (c *Client) do(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 || c.opts.OIDC.Issuer == "" {
+			if err := checkResponse(resp); err != nil {
+				resp.Body.Close()
+				return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL.Path, err)
+			}
+			return resp, nil
+		}
+		resp.Body.Close()
+		token, err := c.requestToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.token = token
+	}
+}
+
+func (c *Client) postJSON(ctx context.Context, endpoint string, body interface{}, result interface{}) error {
+	content, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.opts.URL+endpoint, bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if result == nil {
+		return nil
+	}
+	// an empty or non json response means the server has nothing to add
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	_ = json.Unmarshal(responseBody, result)
+	return nil
+}
+
+func (c *Client) postMultipart(ctx context.Context, endpoint string, fields map[string]string, fileName string, content []byte) error {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			return err
+		}
+	}
+	part, err := writer.CreateFormFile("file", fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(content); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.opts.URL+endpoint, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// UploadChart uploads a chart tgz, the platform adds it to its extensions
+func (c *Client) UploadChart(ctx context.Context, chartPath string) error {
+	content, err := os.ReadFile(chartPath)
+	if err != nil {
+		return err
+	}
+	color.Blue("uploading chart %s", chartPath)
+	if err := c.postMultipart(ctx, "/file", nil, filepath.Base(chartPath), content); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", chartPath, err)
+	}
+	return nil
+}
+
+// uploadState is the progress of a chunked upload, it is identified by the url, the file name
+// and size and the checksum of the first chunk, so that a bundle extracted again still matches
+type uploadState struct {
+	URL        string `json:"url"`
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	ChunkSize  int64  `json:"chunk_size"`
+	FirstChunk string `json:"first_chunk_sha256"`
+	NextIndex  int    `json:"next_index"`
+}
+
+func (c *Client) statePath(name string, size int64) string {
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d", c.opts.URL, name, size)))
+	return filepath.Join(c.opts.StateDir, hex.EncodeToString(key[:8])+".json")
+}
+
+func loadState(statePath string, expected uploadState) int {
+	content, err := os.ReadFile(statePath)
+	if err != nil {
+		return 0
+	}
+	var state uploadState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return 0
+	}
+	next := state.NextIndex
+	state.NextIndex = 0
+	if state != expected {
+		return 0
+	}
+	return next
+}
+
+func saveState(statePath string, state uploadState) error {
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		return err
+	}
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(statePath, content, 0644)
+}
+
+func readChunk(file *os.File, index int, chunkSize int64, size int64) ([]byte, error) {
+	offset := int64(index) * chunkSize
+	length := chunkSize
+	if offset+length > size {
+		length = size - offset
+	}
+	chunk := make([]byte, length)
+	if _, err := file.ReadAt(chunk, offset); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// UploadFile uploads a file in chunks. The index of the next chunk is saved after every chunk,
+// an interrupted upload of the same file continues at this chunk.
+func (c *Client) UploadFile(ctx context.Context, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(filePath)
+	size := info.Size()
+	chunkSize := c.opts.ChunkSize
+	endIndex := int((size + chunkSize - 1) / chunkSize)
+
+	first, err := readChunk(file, 0, chunkSize, size)
+	if err != nil {
+		return err
+	}
+	firstSum := sha256.Sum256(first)
+	state := uploadState{URL: c.opts.URL, Name: name, Size: size, ChunkSize: chunkSize, FirstChunk: hex.EncodeToString(firstSum[:])}
+	statePath := c.statePath(name, size)
+	index := loadState(statePath, state)
+	if index > 0 {
+		color.Blue("resuming upload of %s at chunk %d of %d", name, index+1, endIndex)
+	} else {
+		color.Blue("uploading %s in %d chunks", filePath, endIndex)
+	}
+
+	init := map[string]interface{}{"name": name, "fileSize": size, "chunkSize": chunkSize, "index": index, "endIndex": endIndex}
+	var initResponse struct {
+		Index *int `json:"index"`
+	}
+	if err := c.postJSON(ctx, "/file_chunks_init", init, &initResponse); err != nil {
+		return fmt.Errorf("failed to start the upload of %s: %w", name, err)
+	}
+	if initResponse.Index != nil && *initResponse.Index != index {
+		index = *initResponse.Index
+		if index < 0 || index > endIndex {
+			return fmt.Errorf("server expects chunk %d of %s, which has %d chunks", index, name, endIndex)
+		}
+		color.Yellow("server continues the upload of %s at chunk %d", name, index+1)
+	}
+
+	for ; index < endIndex; index++ {
+		chunk, err := readChunk(file, index, chunkSize, size)
+		if err != nil {
+			return err
+		}
+		fields := map[string]string{"index": strconv.Itoa(index)}
+		for attempt := 1; ; attempt++ {
+			err = c.postMultipart(ctx, "/file_chunks", fields, name, chunk)
+			if err == nil || attempt == chunkRetries || ctx.Err() != nil {
+				break
+			}
+			color.Yellow("chunk %d of %s failed, retrying: %s", index+1, name, err.Error())
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Duration(attempt) * retryDelay):
+			}
+		}
+		if err != nil {
+			c.endProgress()
+			return fmt.Errorf("failed to upload chunk %d of %s, run the upload again to continue: %w", index+1, name, err)
+		}
+		state.NextIndex = index + 1
+		if err := saveState(statePath, state); err != nil {
+			color.Yellow("failed to save the upload state: %s", err.Error())
+		}
+		c.printProgress(name, int64(index)*chunkSize+int64(len(chunk)), size)
+	}
+	c.endProgress()
+	os.Remove(statePath)
+	return nil
+}
+
+func (c *Client) printProgress(name string, done int64, total int64) {
+	if c.opts.Progress == nil {
+		return
+	}
+	percent := int64(100)
+	if total > 0 {
+		percent = done * 100 / total
+	}
+	fmt.Fprintf(c.opts.Progress, "\r%s %3d%% (%s / %s)", name, percent, humanSize(done), humanSize(total))
+}
+
+func (c *Client) endProgress() {
+	if c.opts.Progress != nil {
+		fmt.Fprintln(c.opts.Progress)
+	}
+}
+
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// UploadImages uploads an image archive in chunks and imports it into the containerd of the platform
+func (c *Client) UploadImages(ctx context.Context, tarPath string) error {
+	if err := c.UploadFile(ctx, tarPath); err != nil {
+		return err
+	}
+	color.Blue("importing %s on the platform", filepath.Base(tarPath))
+	if err := c.postJSON(ctx, "/import-container", map[string]string{"filename": filepath.Base(tarPath)}, nil); err != nil {
+		return fmt.Errorf("failed to import %s: %w", tarPath, err)
+	}
+	return nil
+}
+
+// isAvailable checks whether the extensions listed by the platform contain the chart version
+func isAvailable(extensions []map[string]interface{}, name string, version string) bool {
+	for _, ext := range extensions {
+		if ext["name"] != name {
+			continue
+		}
+		if ext["version"] == version {
+			return true
+		}
+		if versions, ok := ext["available_versions"].(map[string]interface{}); ok {
+			if _, ok := versions[version]; ok {
+				return true
+			}
+		}
+		if versions, ok := ext["available_versions"].([]interface{}); ok {
+			for _, v := range versions {
+				if v == version {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// WaitForExtension polls the extensions of the platform until the chart version is listed
+func (c *Client) WaitForExtension(ctx context.Context, name string, version string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+	color.Blue("waiting until the platform lists %s %s", name, version)
+	for {
+		resp, err := c.do(ctx, func() (*http.Request, error) {
+			return http.NewRequestWithContext(ctx, http.MethodGet, c.opts.URL+"/extensions", nil)
+		})
+		if err == nil {
+			var extensions []map[string]interface{}
+			err = json.NewDecoder(resp.Body).Decode(&extensions)
+			resp.Body.Close()
+			if err == nil && isAvailable(extensions, name, version) {
+				return nil
+			}
+		}
+		if err != nil && ctx.Err() == nil {
+			color.Yellow("failed to list the extensions: %s", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s %s is not available on the platform after %s", name, version, c.opts.Timeout)
+		case <-time.After(c.opts.PollInterval):
+		}
+	}
+}
+
+func (c *Client) uploadChartAndWait(ctx context.Context, chartPath string, wait bool) error {
+	ch, err := loader.Load(chartPath)
+	if err != nil {
+		return fmt.Errorf("failed to load chart %s: %w", chartPath, err)
+	}
+	if err := c.UploadChart(ctx, chartPath); err != nil {
+		return err
+	}
+	if !wait {
+		return nil
+	}
+	return c.WaitForExtension(ctx, ch.Metadata.Name, ch.Metadata.Version)
+}
+
+// Upload uploads a chart tgz, an image archive or a bundle. The images of a bundle are uploaded
+// before its charts, so that the images are present when the extension is installed. With wait
+// the upload returns when the platform lists the uploaded charts.
+func (c *Client) Upload(ctx context.Context, path string, wait bool) error {
+	if strings.HasSuffix(path, ".tgz") {
+		return c.uploadChartAndWait(ctx, path, wait)
+	}
+	if !strings.HasSuffix(path, ".tar") {
+		return errors.New(path + " is neither a chart tgz, an image archive nor a bundle")
+	}
+	if _, err := bundle.Inspect(path); err != nil {
+		return c.UploadImages(ctx, path)
+	}
+
+	dir, err := os.MkdirTemp("", "extensionctl-upload-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	color.Blue("extracting bundle %s", path)
+	manifest, err := bundle.Extract(path, dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range manifest.Files {
+		if file.Kind == "images" {
+			if err := c.UploadImages(ctx, filepath.Join(dir, filepath.FromSlash(file.Path))); err != nil {
+				return err
+			}
+		}
+	}
+	for _, file := range manifest.Files {
+		if file.Kind == "chart" {
+			if err := c.uploadChartAndWait(ctx, filepath.Join(dir, filepath.FromSlash(file.Path)), wait); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	helmchart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// platform is a stand-in for the kube-helm backend and the identity provider of the platform
+type platform struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu         sync.Mutex
+	tokens     int
+	token      string
+	charts     map[string][]byte
+	uploads    map[string][]byte
+	chunks     map[string][]int
+	imported   []string
+	extensions []map[string]interface{}
+	// failChunk makes every upload of this chunk index fail
+	failChunk int
+}
+
+func newPlatform(t *testing.T) *platform {
+	p := &platform{t: t, charts: map[string][]byte{}, uploads: map[string][]byte{}, chunks: map[string][]int{}, failChunk: -1}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/realms/kaapana/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"token_endpoint": p.server.URL + "/auth/realms/kaapana/protocol/openid-connect/token"})
+	})
+	mux.HandleFunc("/auth/realms/kaapana/protocol/openid-connect/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "password" || r.FormValue("username") != "kaapana" || r.FormValue("password") != "secret" {
+			http.Error(w, "invalid grant", http.StatusUnauthorized)
+			return
+		}
+		p.mu.Lock()
+		p.tokens++
+		p.token = "token-" + strconv.Itoa(p.tokens)
+		token := p.token
+		p.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"access_token": token})
+	})
+	mux.HandleFunc("/kube-helm-api/file", p.authorized(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		content, _ := io.ReadAll(file)
+		p.charts[header.Filename] = content
+		// the platform lists the extension after the next poll
+		name := strings.TrimSuffix(header.Filename, "-0.1.0.tgz")
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.extensions = append(p.extensions, map[string]interface{}{"name": name, "version": "0.1.0", "available_versions": map[string]interface{}{"0.1.0": map[string]interface{}{}}})
+		}()
+	}))
+	mux.HandleFunc("/kube-helm-api/file_chunks_init", p.authorized(func(w http.ResponseWriter, r *http.Request) {
+		var init struct {
+			Name  string `json:"name"`
+			Index int    `json:"index"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&init); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if init.Index == 0 {
+			p.uploads[init.Name] = []byte{}
+		}
+	}))
+	mux.HandleFunc("/kube-helm-api/file_chunks", p.authorized(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		index, _ := strconv.Atoi(r.FormValue("index"))
+		if index == p.failChunk {
+			http.Error(w, "disk full", http.StatusInternalServerError)
+			return
+		}
+		content, _ := io.ReadAll(file)
+		p.uploads[header.Filename] = append(p.uploads[header.Filename], content...)
+		p.chunks[header.Filename] = append(p.chunks[header.Filename], index)
+	}))
+	mux.HandleFunc("/kube-helm-api/import-container", p.authorized(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if _, ok := p.uploads[body["filename"]]; !ok {
+			http.Error(w, "file not found", http.StatusNotFound)
+			return
+		}
+		p.imported = append(p.imported, body["filename"])
+	}))
+	mux.HandleFunc("/kube-helm-api/extensions", p.authorized(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(p.extensions)
+	}))
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *platform) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.token == "" || r.Header.Get("Authorization") != "Bearer "+p.token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (p *platform) options(t *testing.T) Options {
+	return Options{
+		URL:          p.server.URL + "/kube-helm-api/",
+		OIDC:         OIDCOptions{Issuer: p.server.URL + "/auth/realms/kaapana", ClientID: "kaapana", Username: "kaapana", Password: "secret"},
+		ChunkSize:    4,
+		StateDir:     t.TempDir(),
+		PollInterval: 10 * time.Millisecond,
+		Timeout:      5 * time.Second,
+	}
+}
+
+func TestUploadChart(t *testing.T) {
+	p := newPlatform(t)
+	dir := t.TempDir()
+	ch := &helmchart.Chart{Metadata: &helmchart.Metadata{APIVersion: "v2", Name: "otsus-method", Version: "0.1.0"}}
+	chartPath, err := chartutil.Save(ch, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClient(context.Background(), p.options(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the token expired, the client has to request a new one
+	p.mu.Lock()
+	p.token = "rotated"
+	p.mu.Unlock()
+
+	if err := client.Upload(context.Background(), chartPath, true); err != nil {
+		t.Fatalf("upload failed: %s", err)
+	}
+	if _, ok := p.charts["otsus-method-0.1.0.tgz"]; !ok {
+		t.Fatalf("chart was not uploaded, got %v", p.charts)
+	}
+	if p.tokens != 2 {
+		t.Fatalf("expected a second token after the 401, got %d tokens", p.tokens)
+	}
+
+	opts := p.options(t)
+	opts.OIDC.Password = "wrong"
+	if _, err := NewClient(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "invalid grant") {
+		t.Fatalf("expected the token request to fail, got %v", err)
+	}
+}
+
+func TestUploadImagesResume(t *testing.T) {
+	retryDelay = time.Millisecond
+	p := newPlatform(t)
+	tarPath := filepath.Join(t.TempDir(), "images.tar")
+	content := []byte("0123456789abcdefghij-")
+	if err := os.WriteFile(tarPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := p.options(t)
+	progress := &bytes.Buffer{}
+	opts.Progress = progress
+
+	p.failChunk = 3
+	client, err := NewClient(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Upload(context.Background(), tarPath, true); err == nil || !strings.Contains(err.Error(), "chunk 4 of images.tar") {
+		t.Fatalf("expected chunk 4 to fail, got %v", err)
+	}
+	if len(p.imported) != 0 {
+		t.Fatalf("images were imported after a failed upload")
+	}
+
+	p.failChunk = -1
+	client, err = NewClient(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Upload(context.Background(), tarPath, true); err != nil {
+		t.Fatalf("resumed upload failed: %s", err)
+	}
+	if got := p.chunks["images.tar"]; len(got) != 6 || got[3] != 3 || got[5] != 5 {
+		t.Fatalf("expected chunks 0-5 to be sent once, got %v", got)
+	}
+	if !bytes.Equal(p.uploads["images.tar"], content) {
+		t.Fatalf("server received %q, expected %q", p.uploads["images.tar"], content)
+	}
+	if len(p.imported) != 1 || p.imported[0] != "images.tar" {
+		t.Fatalf("expected images.tar to be imported, got %v", p.imported)
+	}
+	if !strings.Contains(progress.String(), "images.tar 100% (21 B / 21 B)") {
+		t.Fatalf("unexpected progress %q", progress.String())
+	}
+	if entries, _ := os.ReadDir(opts.StateDir); len(entries) != 0 {
+		t.Fatalf("upload state was not removed after the upload")
+	}
+}
+
+func TestWaitForExtensionTimeout(t *testing.T) {
+	p := newPlatform(t)
+	opts := p.options(t)
+	opts.Timeout = 50 * time.Millisecond
+	client, err := NewClient(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WaitForExtension(context.Background(), "otsus-method", "0.2.0"); err == nil {
+		t.Fatalf("expected a timeout")
+	}
+}
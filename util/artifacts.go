@@ -0,0 +1,211 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+const (
+	DefaultOutputDir              = "dist"
+	DefaultChartArtifactTemplate  = "{{.ChartName}}-{{.ChartVersion}}.tgz"
+	DefaultImagesArtifactTemplate = "images.tar"
+	DefaultBundleArtifactTemplate = "{{.Name}}-{{.ExtensionVersion}}.bundle.tar"
+	ArtifactsSummaryFile          = "artifacts.json"
+)
+
+type Artifact struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type artifactTemplateData struct {
+	Name                string
+	ChartName           string
+	ChartVersion        string
+	ExtensionVersion    string
+	ImageTag            string
+	KaapanaBuildVersion string
+}
+
+// ResolveOutputDir sets config.OutputPath from the --output-dir flag, output_dir in the
+// config or <dir_path>/dist, in this order, and creates the folder
+func ResolveOutputDir(config *ExtensionConfig, flagValue string) error {
+	outputDir := flagValue
+	if outputDir == "" {
+		outputDir = config.OutputDir
+	}
+	if outputDir == "" {
+		outputDir = filepath.Join(config.DirPath, DefaultOutputDir)
+	}
+	outputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory %s: %w", outputDir, err)
+	}
+	config.OutputPath = outputDir
+	color.Blue("writing artifacts into %s", outputDir)
+	return nil
+}
+
+func renderArtifactName(config *ExtensionConfig, name string, text string, chartName string) (string, error) {
+	data := artifactTemplateData{
+		Name:                filepath.Base(config.DirPath),
+		ChartName:           chartName,
+		ChartVersion:        config.Versions.Chart,
+		ExtensionVersion:    config.Versions.Extension,
+		ImageTag:            config.Versions.Image,
+		KaapanaBuildVersion: config.KaapanaBuildVersion,
+	}
+	fileName, err := renderTemplate(name, text, data)
+	if err != nil {
+		return "", &ConfigError{Err: err}
+	}
+	if fileName == "" || strings.ContainsAny(fileName, `/\`) {
+		return "", &ConfigError{Err: fmt.Errorf("'%s' rendered from %s is not a valid file name", fileName, name)}
+	}
+	return fileName, nil
+}
+
+// ImagesArtifactPath returns the path the saved images are written to
+func ImagesArtifactPath(config *ExtensionConfig) (string, error) {
+	text := config.ImagesArtifactTemplate
+	if text == "" {
+		text = DefaultImagesArtifactTemplate
+	}
+	fileName, err := renderArtifactName(config, "images_artifact_template", text, "")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(config.OutputPath, fileName), nil
+}
+
+// ChartArtifactPath returns the path the packaged chart is written to
+func ChartArtifactPath(config *ExtensionConfig, chartName string) (string, error) {
+	text := config.ChartArtifactTemplate
+	if text == "" {
+		text = DefaultChartArtifactTemplate
+	}
+	fileName, err := renderArtifactName(config, "chart_artifact_template", text, chartName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(config.OutputPath, fileName), nil
+}
+
+// BundleArtifactPath returns the path the bundle of the chart and images is written to
+func BundleArtifactPath(config *ExtensionConfig) (string, error) {
+	text := config.BundleArtifactTemplate
+	if text == "" {
+		text = DefaultBundleArtifactTemplate
+	}
+	fileName, err := renderArtifactName(config, "bundle_artifact_template", text, "")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(config.OutputPath, fileName), nil
+}
+
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+func NewArtifact(kind string, path string) (Artifact, error) {
+	checksum, size, err := fileSHA256(path)
+	if err != nil {
+		return Artifact{}, fmt.Errorf("failed to compute checksum of %s: %w", path, err)
+	}
+	return Artifact{Kind: kind, Path: path, Size: size, SHA256: checksum}, nil
+}
+
+// RecordArtifacts merges artifacts into artifacts.json in outputDir and returns all recorded
+// artifacts. Entries of files that don't exist anymore are dropped.
+func RecordArtifacts(outputDir string, artifacts ...Artifact) ([]Artifact, error) {
+	summaryPath := filepath.Join(outputDir, ArtifactsSummaryFile)
+
+	recorded := []Artifact{}
+	content, err := os.ReadFile(summaryPath)
+	if err == nil {
+		if err := json.Unmarshal(content, &recorded); err != nil {
+			color.Yellow("ignoring invalid %s: %s", summaryPath, err.Error())
+			recorded = []Artifact{}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	byPath := map[string]Artifact{}
+	for _, artifact := range recorded {
+		if _, err := os.Stat(artifact.Path); err == nil {
+			byPath[artifact.Path] = artifact
+		}
+	}
+	for _, artifact := range artifacts {
+		byPath[artifact.Path] = artifact
+	}
+
+	merged := make([]Artifact, 0, len(byPath))
+	for _, artifact := range byPath {
+		merged = append(merged, artifact)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Kind != merged[j].Kind {
+			return merged[i].Kind < merged[j].Kind
+		}
+		return merged[i].Path < merged[j].Path
+	})
+
+	file, err := json.MarshalIndent(merged, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(summaryPath, file, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", summaryPath, err)
+	}
+	return merged, nil
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func PrintArtifacts(artifacts []Artifact, outputDir string) {
+	color.Green("Artifacts in %s:", outputDir)
+	for _, artifact := range artifacts {
+		color.Green("- %-7s %s (%s, sha256:%s)", artifact.Kind, artifact.Path, formatSize(artifact.Size), artifact.SHA256)
+	}
+	color.Green("summary written to %s", filepath.Join(outputDir, ArtifactsSummaryFile))
+}
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"os"
@@ -19,21 +20,50 @@ type ExtensionConfig struct {
 	NoOverwriteOperators bool     `json:"no_overwrite_operators"`
 	CustomRegistryUrl    string   `json:"custom_registry_url"`
 	ContainerEngine      string   `json:"container_engine"`
-	ChartPath            string   `json:"chart_path"`
+	ChartPath            string   `json:"chart_path,omitempty"`
+	ChartPaths           []string `json:"chart_paths"`
+	ExtensionVersion     string   `json:"extension_version"`
+	GitDirtyVersion      bool     `json:"git_dirty_version,omitempty"`
+	ImageTagTemplate     string   `json:"image_tag_template"`
+	ChartVersionTemplate string   `json:"chart_version_template"`
+	PlatformImages       []string `json:"platform_images,omitempty"`
+
+	// ExtraValues keep their json type, strings, numbers, booleans and null are supported
+	ExtraValues map[string]interface{} `json:"extra_values"`
+
+	OutputDir              string `json:"output_dir"`
+	ChartArtifactTemplate  string `json:"chart_artifact_template"`
+	ImagesArtifactTemplate string `json:"images_artifact_template"`
+	BundleArtifactTemplate string `json:"bundle_artifact_template"`
+
+	ExtensionsDir string `json:"extensions_dir"`
+
+	Versions   BuildVersions `json:"-"`
+	OutputPath string        `json:"-"`
 }
 
-func ParseConfigFile(configPath string, noSave bool, noRebuild bool) (*ExtensionConfig, error) {
-	color.Blue("parsing config file")
+// ReadConfigFile only reads the config file, without completing it from the cluster
+func ReadConfigFile(configPath string) (*ExtensionConfig, error) {
 	file, err := os.ReadFile(configPath)
 	if err != nil {
-		return nil, err
+		return nil, &ConfigError{Path: configPath, Err: err}
 	}
 
 	var config ExtensionConfig
 	err = json.Unmarshal(file, &config)
+	if err != nil {
+		return nil, &ConfigError{Path: configPath, Err: err}
+	}
+	return &config, nil
+}
+
+func ParseConfigFile(ctx context.Context, configPath string, noSave bool, noRebuild bool) (*ExtensionConfig, error) {
+	color.Blue("parsing config file")
+	parsed, err := ReadConfigFile(configPath)
 	if err != nil {
 		return nil, err
 	}
+	config := *parsed
 	config.NoSave = noSave
 	config.NoRebuild = noRebuild
 
@@ -42,12 +72,16 @@ func ParseConfigFile(configPath string, noSave bool, noRebuild bool) (*Extension
 	}
 
 	if config.KaapanaBuildVersion == "" || config.CustomRegistryUrl == "" {
-		deployment, err := KubeGetDeployment("kube-helm-deployment", "admin")
+		clientset, err := KubeClientset()
+		if err != nil {
+			return nil, err
+		}
+		platform, err := DiscoverPlatform(ctx, clientset, KaapanaNamespace())
 		if err != nil {
 			return nil, err
 		}
 		if config.KaapanaBuildVersion == "" {
-			version, err := GetEnvVarFromDeployment(deployment, "KAAPANA_BUILD_VERSION")
+			version, err := platform.GetEnv("KAAPANA_BUILD_VERSION")
 			if err != nil {
 				return nil, err
 			}
@@ -55,7 +89,7 @@ func ParseConfigFile(configPath string, noSave bool, noRebuild bool) (*Extension
 			config.KaapanaBuildVersion = version
 		}
 		if config.CustomRegistryUrl == "" {
-			registryURL, err := GetEnvVarFromDeployment(deployment, "REGISTRY_URL")
+			registryURL, err := platform.GetEnv("REGISTRY_URL")
 			if err != nil {
 				return nil, err
 			}
@@ -64,6 +98,11 @@ func ParseConfigFile(configPath string, noSave bool, noRebuild bool) (*Extension
 		}
 	}
 
+	// resolved before anything is written, the version can be derived from the state of the git repository
+	if err := ResolveVersions(ctx, &config); err != nil {
+		return nil, &ConfigError{Path: configPath, Err: err}
+	}
+
 	err = WriteConfigFile(&config, configPath)
 	if err != nil {
 		return nil, err
@@ -71,20 +110,26 @@ func ParseConfigFile(configPath string, noSave bool, noRebuild bool) (*Extension
 
 	// TODO: make sure CustomRegistryUrl doesn't start with "https://" and doesn't end with "/"
 
+	Emit(Event{Type: EventConfigResolved, File: configPath, ExtensionVersion: config.Versions.Extension, KaapanaBuildVersion: config.KaapanaBuildVersion, Registry: config.CustomRegistryUrl})
 	return &config, nil
 }
 
+// ForChart returns a copy of the config with ChartPath set to the chart that is processed
+func (config *ExtensionConfig) ForChart(chartPath string) *ExtensionConfig {
+	chartConfig := *config
+	chartConfig.ChartPath = chartPath
+	return &chartConfig
+}
+
 func WriteConfigFile(config *ExtensionConfig, configPath string) error {
 	file, err := json.MarshalIndent(config, "", "    ")
 	if err != nil {
-		color.Red(err.Error())
-		return err
+		return &ConfigError{Path: configPath, Err: err}
 	}
 
 	err = os.WriteFile(configPath, file, 0644)
 	if err != nil {
-		color.Red(err.Error())
-		return err
+		return &ConfigError{Path: configPath, Err: err}
 	}
 
 	return nil
@@ -96,15 +141,11 @@ func isAbsolutePath(path string) bool {
 
 func ValidateConfig(dirPath string, kaapanaPath string) error {
 	if dirPath == "" || kaapanaPath == "" {
-		err := errors.New("<dir_path> or <kaapana_path> is empty")
-		color.Red(err.Error())
-		return err
+		return &ConfigError{Err: errors.New("<dir_path> or <kaapana_path> is empty")}
 	}
 
 	if !isAbsolutePath(dirPath) || !isAbsolutePath(kaapanaPath) {
-		err := errors.New("<dir_path> or <kaapana_path> is not a valid absolute path")
-		color.Red(err.Error())
-		return err
+		return &ConfigError{Err: errors.New("<dir_path> or <kaapana_path> is not a valid absolute path")}
 	}
 
 	return nil
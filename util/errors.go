@@ -0,0 +1,32 @@
+package util
+
+// ConfigError is a config file that can't be read or written, or an invalid value in it
+type ConfigError struct {
+	Path string
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Path == "" {
+		return "config: " + e.Err.Error()
+	}
+	return "config " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ClusterError is a failure to reach the cluster or the platform, or to read or change
+// something in it
+type ClusterError struct {
+	Err error
+}
+
+func (e *ClusterError) Error() string {
+	return "cluster: " + e.Err.Error()
+}
+
+func (e *ClusterError) Unwrap() error {
+	return e.Err
+}
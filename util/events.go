@@ -0,0 +1,119 @@
+package util
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// EventFormats are the values of --events
+var EventFormats = []string{"json"}
+
+// Types of the events emitted during a build
+const (
+	EventConfigResolved = "config_resolved"
+	EventPrereqFound    = "prereq_found"
+	EventBuildStarted   = "build_started"
+	EventBuildFinished  = "build_finished"
+	EventSaveStarted    = "save_started"
+	EventSaveProgress   = "save_progress"
+	EventSaveFinished   = "save_finished"
+	EventChartEdited    = "chart_edited"
+	EventChartPackaged  = "chart_packaged"
+	EventError          = "error"
+)
+
+// Status of an image in a build_finished event
+const (
+	BuildStatusBuilt = "built"
+	// BuildStatusCached is a build that resulted in the image that existed before
+	BuildStatusCached  = "cached"
+	BuildStatusSkipped = "skipped"
+	BuildStatusFailed  = "failed"
+)
+
+// Event is one line of the --events json stream. Only the fields that belong to the type of
+// the event are set.
+type Event struct {
+	Time                time.Time `json:"time"`
+	Type                string    `json:"type"`
+	Image               string    `json:"image,omitempty"`
+	Dockerfile          string    `json:"dockerfile,omitempty"`
+	Status              string    `json:"status,omitempty"`
+	Chart               string    `json:"chart,omitempty"`
+	Name                string    `json:"name,omitempty"`
+	File                string    `json:"file,omitempty"`
+	ExtensionVersion    string    `json:"extension_version,omitempty"`
+	KaapanaBuildVersion string    `json:"kaapana_build_version,omitempty"`
+	Registry            string    `json:"registry,omitempty"`
+	Version             string    `json:"version,omitempty"`
+	Bytes               int64     `json:"bytes,omitempty"`
+	Layers              int       `json:"layers,omitempty"`
+	DurationMS          int64     `json:"duration_ms,omitempty"`
+	Message             string    `json:"message,omitempty"`
+	ExitCode            int       `json:"exit_code,omitempty"`
+}
+
+var (
+	eventsMu       sync.Mutex
+	eventsOutput   io.Writer
+	eventsHandlers = map[int]func(Event){}
+	nextHandler    int
+)
+
+// SetEventOutput makes Emit write newline-delimited JSON events to w, nil disables the events
+func SetEventOutput(w io.Writer) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	eventsOutput = w
+}
+
+// OnEvent calls handler for every emitted event until the returned function is called, the
+// handler must not emit events itself
+func OnEvent(handler func(Event)) func() {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	id := nextHandler
+	nextHandler++
+	eventsHandlers[id] = handler
+	return func() {
+		eventsMu.Lock()
+		defer eventsMu.Unlock()
+		delete(eventsHandlers, id)
+	}
+}
+
+// EventsEnabled returns whether events are written or handled, callers can skip preparing
+// expensive events
+func EventsEnabled() bool {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	return eventsOutput != nil || len(eventsHandlers) > 0
+}
+
+// Emit passes the event to the handlers and writes it as a single line, the time is set if it
+// is empty
+func Emit(event Event) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	for _, handler := range eventsHandlers {
+		handler(event)
+	}
+	if eventsOutput == nil {
+		return
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	eventsOutput.Write(append(line, '\n'))
+}
+
+// Since returns the duration since start in milliseconds for the duration_ms of an event
+func Since(start time.Time) int64 {
+	return time.Since(start).Milliseconds()
+}
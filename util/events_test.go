@@ -0,0 +1,40 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestEmit(t *testing.T) {
+	Emit(Event{Type: EventBuildStarted, Image: "ignored"})
+
+	var out bytes.Buffer
+	SetEventOutput(&out)
+	defer SetEventOutput(nil)
+	Emit(Event{Type: EventBuildStarted, Image: "registry.example.com/otsus-method:0.1.0"})
+	Emit(Event{Type: EventBuildFinished, Image: "registry.example.com/otsus-method:0.1.0", Status: BuildStatusBuilt, DurationMS: 1200})
+
+	events := []map[string]interface{}{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var event map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("line %q is not a json object: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0]["type"] != EventBuildStarted || events[0]["time"] == "" {
+		t.Fatalf("unexpected first event %v", events[0])
+	}
+	if _, ok := events[0]["status"]; ok {
+		t.Fatalf("empty fields should be omitted, got %v", events[0])
+	}
+	if events[1]["status"] != BuildStatusBuilt || events[1]["duration_ms"] != float64(1200) {
+		t.Fatalf("unexpected second event %v", events[1])
+	}
+}
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// KillDelay is how long a command can take to exit after it was interrupted before it is killed
+const KillDelay = 10 * time.Second
+
+// Command is exec.CommandContext, except that the process is interrupted instead of killed when
+// ctx is done, so that container engines stop the build and remove their build containers. The
+// process is killed if it did not exit after KillDelay.
+func Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	command := exec.CommandContext(ctx, name, args...)
+	command.Cancel = func() error {
+		return command.Process.Signal(os.Interrupt)
+	}
+	command.WaitDelay = KillDelay
+	return command
+}
+
+// ContextError returns the error of ctx if it is done, a command interrupted because of ctx
+// fails with 'signal: interrupt' which does not say why
+func ContextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -2,75 +2,106 @@ package util
 
 import (
 	"context"
-	"flag"
+	"errors"
 	"fmt"
-	"path/filepath"
-
-	"github.com/fatih/color"
+	"sync"
 
 	appv1 "k8s.io/api/apps/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 )
 
-func KubeGetDeployment(deploymentName string, namespace string) (*appv1.Deployment, error) {
-	var kubeconfig *string
-	if home := homedir.HomeDir(); home != "" {
-		kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-	} else {
-		kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
+// DefaultKaapanaNamespace is the namespace of the platform services such as kube-helm
+const DefaultKaapanaNamespace = "admin"
+
+// KubeOptions select the cluster, they are set from the --kubeconfig, --context and
+// --kaapana-namespace flags
+type KubeOptions struct {
+	// Kubeconfig overrides the KUBECONFIG env variable, which can list multiple files
+	Kubeconfig       string
+	Context          string
+	KaapanaNamespace string
+}
+
+var (
+	kubeMu        sync.Mutex
+	kubeOptions   KubeOptions
+	kubeClientset kubernetes.Interface
+)
+
+// SetKubeOptions sets the options used by KubeClientset, the shared clientset is created again
+func SetKubeOptions(opts KubeOptions) {
+	kubeMu.Lock()
+	defer kubeMu.Unlock()
+	kubeOptions = opts
+	kubeClientset = nil
+}
+
+func GetKubeOptions() KubeOptions {
+	kubeMu.Lock()
+	defer kubeMu.Unlock()
+	return kubeOptions
+}
+
+func KaapanaNamespace() string {
+	if namespace := GetKubeOptions().KaapanaNamespace; namespace != "" {
+		return namespace
 	}
-	flag.Parse()
+	return DefaultKaapanaNamespace
+}
 
-	// use the current context in kubeconfig
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+// KubeClientConfig loads the kubeconfig like kubectl does: --kubeconfig, otherwise the files in
+// KUBECONFIG, otherwise ~/.kube/config. Without any kubeconfig the in-cluster config is used.
+func KubeClientConfig(opts KubeOptions) clientcmd.ClientConfig {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = opts.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: opts.Context}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+}
+
+func KubeRESTConfig(opts KubeOptions) (*rest.Config, error) {
+	config, err := KubeClientConfig(opts).ClientConfig()
+	if clientcmd.IsEmptyConfig(err) {
+		return nil, errors.New("no kubeconfig found and not running inside a cluster, set --kubeconfig or KUBECONFIG")
+	}
 	if err != nil {
-		panic(err.Error())
+		if opts.Context != "" {
+			return nil, fmt.Errorf("failed to load kubeconfig for context %s: %w", opts.Context, err)
+		}
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	return config, nil
+}
+
+// KubeClientset returns the clientset shared by all packages, it is created on first use
+func KubeClientset() (kubernetes.Interface, error) {
+	kubeMu.Lock()
+	defer kubeMu.Unlock()
+	if kubeClientset != nil {
+		return kubeClientset, nil
 	}
 
-	// create the clientset
+	config, err := KubeRESTConfig(kubeOptions)
+	if err != nil {
+		return nil, &ClusterError{Err: err}
+	}
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		panic(err.Error())
+		return nil, &ClusterError{Err: fmt.Errorf("failed to create kubernetes client: %w", err)}
 	}
+	kubeClientset = clientset
+	return kubeClientset, nil
+}
 
-	var deployment *appv1.Deployment
-
-	deployment, err = clientset.AppsV1().Deployments(namespace).Get(context.TODO(), deploymentName, metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		color.Red("Deployment %s in namespace %s not found", deploymentName, namespace)
-		return nil, err
-	} else if statusError, isStatus := err.(*errors.StatusError); isStatus {
-		color.Red("Status error while getting deployment %s in namespace %s: %v", deploymentName, namespace, statusError.ErrStatus.Message)
-		return nil, err
+func getDeployment(ctx context.Context, clientset kubernetes.Interface, deploymentName string, namespace string) (*appv1.Deployment, error) {
+	deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("deployment %s in namespace %s not found: %w", deploymentName, namespace, err)
 	} else if err != nil {
-		color.Red("Can not get deployment %s in namespace %s", deploymentName, namespace)
-		return nil, err
+		return nil, fmt.Errorf("can not get deployment %s in namespace %s: %w", deploymentName, namespace, err)
 	}
 	return deployment, nil
 }
-
-func GetEnvVarFromDeployment(deployment *appv1.Deployment, envVarName string) (string, error) {
-	val := ""
-	valFound := false
-	containers := deployment.Spec.Template.Spec.Containers
-	if len(containers) > 1 {
-		color.Yellow(
-			"More than one (%d) containers found in %s, using the first one with image %s", len(containers), deployment.Name, containers[0].Image)
-	}
-	for _, envVar := range containers[0].Env {
-		if envVar.Name == envVarName {
-			valFound = true
-			val = envVar.Value
-			break
-		}
-	}
-	if !valFound {
-		return "", fmt.Errorf("KAAPANA_BUILD_VERSION does not exist in env variables of deployment %s", deployment.Name)
-	}
-	color.Blue("Variable %s has value %s", envVarName, val)
-	return val, nil
-}
@@ -0,0 +1,106 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: %[1]s
+  cluster:
+    server: https://%[1]s.example.com:6443
+users:
+- name: %[1]s
+  user:
+    token: secret
+contexts:
+- name: %[1]s
+  context:
+    cluster: %[1]s
+    user: %[1]s
+`
+
+func writeKubeconfig(t *testing.T, dir string, name string, currentContext string) string {
+	t.Helper()
+	content := fmt.Sprintf(testKubeconfig, name)
+	if currentContext != "" {
+		content += "current-context: " + currentContext + "\n"
+	}
+	path := filepath.Join(dir, name+".yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKubeRESTConfig(t *testing.T) {
+	dir := t.TempDir()
+	dev := writeKubeconfig(t, dir, "dev", "dev")
+	prod := writeKubeconfig(t, dir, "prod", "")
+
+	// KUBECONFIG with multiple files, the current context comes from the first file
+	t.Setenv("KUBECONFIG", dev+string(os.PathListSeparator)+prod)
+	config, err := KubeRESTConfig(KubeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Host != "https://dev.example.com:6443" {
+		t.Fatalf("expected the current context dev, got %s", config.Host)
+	}
+
+	config, err = KubeRESTConfig(KubeOptions{Context: "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Host != "https://prod.example.com:6443" {
+		t.Fatalf("expected context prod, got %s", config.Host)
+	}
+
+	if _, err := KubeRESTConfig(KubeOptions{Context: "missing"}); err == nil {
+		t.Fatalf("expected an error for a missing context")
+	}
+
+	// --kubeconfig takes precedence over KUBECONFIG
+	config, err = KubeRESTConfig(KubeOptions{Kubeconfig: prod, Context: "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Host != "https://prod.example.com:6443" {
+		t.Fatalf("expected context prod, got %s", config.Host)
+	}
+
+	if _, err := KubeRESTConfig(KubeOptions{Kubeconfig: filepath.Join(dir, "missing.yaml")}); err == nil {
+		t.Fatalf("expected an error for a missing kubeconfig")
+	}
+}
+
+func TestKubeClientsetShared(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("KUBECONFIG", writeKubeconfig(t, dir, "dev", "dev"))
+	defer SetKubeOptions(KubeOptions{})
+
+	SetKubeOptions(KubeOptions{KaapanaNamespace: "kaapana-admin"})
+	first, err := KubeClientset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := KubeClientset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatalf("expected one shared clientset")
+	}
+	if KaapanaNamespace() != "kaapana-admin" {
+		t.Fatalf("unexpected kaapana namespace %s", KaapanaNamespace())
+	}
+
+	SetKubeOptions(KubeOptions{})
+	if KaapanaNamespace() != DefaultKaapanaNamespace {
+		t.Fatalf("expected the default kaapana namespace, got %s", KaapanaNamespace())
+	}
+}
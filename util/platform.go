@@ -0,0 +1,260 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fatih/color"
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	KubeHelmDeployment = "kube-helm-deployment"
+	KubeHelmContainer  = "kube-helm"
+	// registry secret Kaapana creates in its namespaces when the registry needs credentials
+	defaultRegistrySecret = "registry-secret"
+)
+
+// Platform are the facts about a running Kaapana instance, read from the kube-helm deployment
+type Platform struct {
+	Namespace         string `json:"namespace"`
+	Version           string `json:"version"`
+	RegistryURL       string `json:"registry_url"`
+	KubernetesVersion string `json:"kubernetes_version"`
+	// Namespaces maps the *_NAMESPACE env variables of kube-helm, e.g. services: services
+	Namespaces        map[string]string `json:"namespaces"`
+	RegistrySecrets   []string          `json:"registry_secrets"`
+	NodeArchitectures []string          `json:"node_architectures"`
+	// Env is the resolved env of the kube-helm container
+	Env map[string]string `json:"-"`
+}
+
+// findContainer returns the container with the given name, or the only container of the deployment
+func findContainer(deployment *appv1.Deployment, name string) (*corev1.Container, error) {
+	containers := deployment.Spec.Template.Spec.Containers
+	names := []string{}
+	for i := range containers {
+		if containers[i].Name == name {
+			return &containers[i], nil
+		}
+		names = append(names, containers[i].Name)
+	}
+	if len(containers) == 1 {
+		return &containers[0], nil
+	}
+	return nil, fmt.Errorf("deployment %s has no container %s, found %s", deployment.Name, name, strings.Join(names, ", "))
+}
+
+func configMapValue(ctx context.Context, clientset kubernetes.Interface, namespace string, name string, key string) (string, bool, error) {
+	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get configmap %s: %w", name, err)
+	}
+	value, ok := configMap.Data[key]
+	return value, ok, nil
+}
+
+func secretValue(ctx context.Context, clientset kubernetes.Interface, namespace string, name string, key string) (string, bool, error) {
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get secret %s: %w", name, err)
+	}
+	value, ok := secret.Data[key]
+	return string(value), ok, nil
+}
+
+// ResolveEnv returns the env of a container like kubelet sets it: envFrom first, then env with
+// values from ConfigMaps and Secrets. Field and resource references are skipped.
+func ResolveEnv(ctx context.Context, clientset kubernetes.Interface, namespace string, container *corev1.Container) (map[string]string, error) {
+	env := map[string]string{}
+
+	for _, source := range container.EnvFrom {
+		if source.ConfigMapRef != nil {
+			configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, source.ConfigMapRef.Name, metav1.GetOptions{})
+			if err != nil {
+				if apierrors.IsNotFound(err) && source.ConfigMapRef.Optional != nil && *source.ConfigMapRef.Optional {
+					continue
+				}
+				return nil, fmt.Errorf("failed to get configmap %s: %w", source.ConfigMapRef.Name, err)
+			}
+			for key, value := range configMap.Data {
+				env[source.Prefix+key] = value
+			}
+		}
+		if source.SecretRef != nil {
+			secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, source.SecretRef.Name, metav1.GetOptions{})
+			if err != nil {
+				if apierrors.IsNotFound(err) && source.SecretRef.Optional != nil && *source.SecretRef.Optional {
+					continue
+				}
+				return nil, fmt.Errorf("failed to get secret %s: %w", source.SecretRef.Name, err)
+			}
+			for key, value := range secret.Data {
+				env[source.Prefix+key] = string(value)
+			}
+		}
+	}
+
+	for _, envVar := range container.Env {
+		if envVar.ValueFrom == nil {
+			env[envVar.Name] = envVar.Value
+			continue
+		}
+
+		var value string
+		var found bool
+		var err error
+		optional := false
+		switch {
+		case envVar.ValueFrom.ConfigMapKeyRef != nil:
+			ref := envVar.ValueFrom.ConfigMapKeyRef
+			optional = ref.Optional != nil && *ref.Optional
+			value, found, err = configMapValue(ctx, clientset, namespace, ref.Name, ref.Key)
+		case envVar.ValueFrom.SecretKeyRef != nil:
+			ref := envVar.ValueFrom.SecretKeyRef
+			optional = ref.Optional != nil && *ref.Optional
+			value, found, err = secretValue(ctx, clientset, namespace, ref.Name, ref.Key)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			if optional {
+				continue
+			}
+			return nil, fmt.Errorf("value of env variable %s of container %s not found", envVar.Name, container.Name)
+		}
+		env[envVar.Name] = value
+	}
+	return env, nil
+}
+
+// DiscoverPlatform reads the facts about the platform from the kube-helm deployment in namespace
+func DiscoverPlatform(ctx context.Context, clientset kubernetes.Interface, namespace string) (*Platform, error) {
+	deployment, err := getDeployment(ctx, clientset, KubeHelmDeployment, namespace)
+	if err != nil {
+		return nil, &ClusterError{Err: err}
+	}
+	container, err := findContainer(deployment, KubeHelmContainer)
+	if err != nil {
+		return nil, &ClusterError{Err: err}
+	}
+	env, err := ResolveEnv(ctx, clientset, namespace, container)
+	if err != nil {
+		return nil, &ClusterError{Err: err}
+	}
+
+	platform := &Platform{
+		Namespace:         namespace,
+		Version:           env["KAAPANA_BUILD_VERSION"],
+		RegistryURL:       env["REGISTRY_URL"],
+		Namespaces:        map[string]string{},
+		RegistrySecrets:   []string{},
+		NodeArchitectures: []string{},
+		Env:               env,
+	}
+	for name, value := range env {
+		if strings.HasSuffix(name, "_NAMESPACE") {
+			platform.Namespaces[strings.ToLower(strings.TrimSuffix(name, "_NAMESPACE"))] = value
+		}
+	}
+
+	for _, secret := range deployment.Spec.Template.Spec.ImagePullSecrets {
+		platform.RegistrySecrets = append(platform.RegistrySecrets, secret.Name)
+	}
+	if len(platform.RegistrySecrets) == 0 {
+		if _, err := clientset.CoreV1().Secrets(namespace).Get(ctx, defaultRegistrySecret, metav1.GetOptions{}); err == nil {
+			platform.RegistrySecrets = append(platform.RegistrySecrets, defaultRegistrySecret)
+		}
+	}
+
+	// the following facts are informational, missing permissions don't fail the discovery
+	if version, err := clientset.Discovery().ServerVersion(); err == nil {
+		platform.KubernetesVersion = version.GitVersion
+	} else {
+		color.Yellow("failed to get the kubernetes version: %s", err.Error())
+	}
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		color.Yellow("failed to list nodes: %s", err.Error())
+	} else {
+		architectures := map[string]bool{}
+		for _, node := range nodes.Items {
+			architectures[node.Status.NodeInfo.Architecture] = true
+		}
+		for architecture := range architectures {
+			platform.NodeArchitectures = append(platform.NodeArchitectures, architecture)
+		}
+		sort.Strings(platform.NodeArchitectures)
+	}
+
+	return platform, nil
+}
+
+// GetEnv returns an env variable of the kube-helm container
+func (platform *Platform) GetEnv(name string) (string, error) {
+	value, ok := platform.Env[name]
+	if !ok {
+		return "", &ClusterError{Err: fmt.Errorf("%s does not exist in env variables of %s in namespace %s", name, KubeHelmDeployment, platform.Namespace)}
+	}
+	color.Blue("Variable %s has value %s", name, value)
+	return value, nil
+}
+
+// Print writes the platform facts as a list, or as json or yaml
+func (platform *Platform) Print(w io.Writer, format string) error {
+	switch format {
+	case "", "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Namespace:\t%s\n", platform.Namespace)
+		fmt.Fprintf(tw, "Version:\t%s\n", platform.Version)
+		fmt.Fprintf(tw, "Registry:\t%s\n", platform.RegistryURL)
+		fmt.Fprintf(tw, "Registry secrets:\t%s\n", strings.Join(platform.RegistrySecrets, ", "))
+		fmt.Fprintf(tw, "Kubernetes version:\t%s\n", platform.KubernetesVersion)
+		fmt.Fprintf(tw, "Node architectures:\t%s\n", strings.Join(platform.NodeArchitectures, ", "))
+		fmt.Fprintf(tw, "Namespaces:\t\n")
+		names := []string{}
+		for name := range platform.Namespaces {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(tw, "  %s:\t%s\n", name, platform.Namespaces[name])
+		}
+		return tw.Flush()
+	case "json":
+		content, err := json.MarshalIndent(platform, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(content))
+		return err
+	case "yaml":
+		content, err := yaml.Marshal(platform)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(content)
+		return err
+	default:
+		return fmt.Errorf("unknown output format '%s', use one of table|json|yaml", format)
+	}
+}
@@ -0,0 +1,105 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	appv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func kubeHelmDeployment(containers ...corev1.Container) *appv1.Deployment {
+	return &appv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: KubeHelmDeployment, Namespace: "admin"},
+		Spec: appv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers:       containers,
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry-secret"}},
+		}}},
+	}
+}
+
+func TestDiscoverPlatform(t *testing.T) {
+	optional := true
+	clientset := fake.NewSimpleClientset(
+		kubeHelmDeployment(
+			corev1.Container{Name: "proxy", Env: []corev1.EnvVar{{Name: "KAAPANA_BUILD_VERSION", Value: "wrong"}}},
+			corev1.Container{
+				Name:    KubeHelmContainer,
+				EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "kaapana-namespaces"}}}},
+				Env: []corev1.EnvVar{
+					{Name: "KAAPANA_BUILD_VERSION", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "kaapana-config"}, Key: "version"}}},
+					{Name: "REGISTRY_URL", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "kaapana-registry"}, Key: "url"}}},
+					{Name: "OFFLINE_MODE", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}, Key: "offline", Optional: &optional}}},
+					{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+				},
+			},
+		),
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kaapana-config", Namespace: "admin"}, Data: map[string]string{"version": "0.2.0"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kaapana-namespaces", Namespace: "admin"}, Data: map[string]string{"SERVICES_NAMESPACE": "services", "ADMIN_NAMESPACE": "admin"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "kaapana-registry", Namespace: "admin"}, Data: map[string][]byte{"url": []byte("registry.example.com/kaapana")}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}, Status: corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{Architecture: "amd64"}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}, Status: corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{Architecture: "arm64"}}},
+	)
+
+	platform, err := DiscoverPlatform(context.Background(), clientset, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if platform.Version != "0.2.0" || platform.RegistryURL != "registry.example.com/kaapana" {
+		t.Fatalf("unexpected version %s and registry %s", platform.Version, platform.RegistryURL)
+	}
+	if _, ok := platform.Env["OFFLINE_MODE"]; ok {
+		t.Fatalf("missing optional configmap should be skipped")
+	}
+	if !reflect.DeepEqual(platform.Namespaces, map[string]string{"services": "services", "admin": "admin"}) {
+		t.Fatalf("unexpected namespaces %v", platform.Namespaces)
+	}
+	if !reflect.DeepEqual(platform.RegistrySecrets, []string{"registry-secret"}) {
+		t.Fatalf("unexpected registry secrets %v", platform.RegistrySecrets)
+	}
+	if !reflect.DeepEqual(platform.NodeArchitectures, []string{"amd64", "arm64"}) {
+		t.Fatalf("unexpected node architectures %v", platform.NodeArchitectures)
+	}
+
+	if _, err := platform.GetEnv("HELM_NAMESPACE"); err == nil || !strings.Contains(err.Error(), "HELM_NAMESPACE") {
+		t.Fatalf("expected an error naming the missing variable, got %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := platform.Print(&out, "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "registry_url: registry.example.com/kaapana") {
+		t.Fatalf("unexpected yaml output:\n%s", out.String())
+	}
+}
+
+func TestDiscoverPlatformErrors(t *testing.T) {
+	// a required secret that doesn't exist
+	clientset := fake.NewSimpleClientset(kubeHelmDeployment(corev1.Container{
+		Name: KubeHelmContainer,
+		Env: []corev1.EnvVar{{Name: "REGISTRY_URL", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "kaapana-registry"}, Key: "url"}}}},
+	}))
+	if _, err := DiscoverPlatform(context.Background(), clientset, "admin"); err == nil {
+		t.Fatalf("expected an error for a missing secret")
+	}
+
+	// several containers, none of them named kube-helm
+	clientset = fake.NewSimpleClientset(kubeHelmDeployment(corev1.Container{Name: "a"}, corev1.Container{Name: "b"}))
+	if _, err := DiscoverPlatform(context.Background(), clientset, "admin"); err == nil {
+		t.Fatalf("expected an error if the container can't be found")
+	}
+
+	if _, err := DiscoverPlatform(context.Background(), fake.NewSimpleClientset(), "admin"); err == nil {
+		t.Fatalf("expected an error for a missing deployment")
+	}
+}
@@ -0,0 +1,188 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	BuildReportFile         = "build-report.json"
+	BuildReportMarkdownFile = "build-report.md"
+)
+
+// ImageReport is an image built, taken from the cache or skipped during the build
+type ImageReport struct {
+	Image      string `json:"image"`
+	Dockerfile string `json:"dockerfile"`
+	Status     string `json:"status"`
+	DurationMS int64  `json:"duration_ms"`
+	Size       int64  `json:"size"`
+	Layers     int    `json:"layers"`
+}
+
+// ChartReport is a chart packaged during the build
+type ChartReport struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Path       string `json:"path"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// BuildReport summarizes a build. The images and charts are collected from the events of the
+// build with Record.
+type BuildReport struct {
+	Name                string        `json:"name"`
+	ExtensionVersion    string        `json:"extension_version"`
+	KaapanaBuildVersion string        `json:"kaapana_build_version"`
+	Registry            string        `json:"registry"`
+	Started             time.Time     `json:"started"`
+	DurationMS          int64         `json:"duration_ms"`
+	Images              []ImageReport `json:"images"`
+	Charts              []ChartReport `json:"charts"`
+	Artifacts           []Artifact    `json:"artifacts"`
+}
+
+func NewBuildReport() *BuildReport {
+	return &BuildReport{Started: time.Now().UTC(), Images: []ImageReport{}, Charts: []ChartReport{}, Artifacts: []Artifact{}}
+}
+
+// Record adds the image of a build_finished event and the chart of a chart_packaged event to the
+// report, an image or chart that is reported again replaces the earlier entry
+func (r *BuildReport) Record(event Event) {
+	switch event.Type {
+	case EventBuildFinished:
+		image := ImageReport{Image: event.Image, Dockerfile: event.Dockerfile, Status: event.Status, DurationMS: event.DurationMS, Size: event.Bytes, Layers: event.Layers}
+		for i := range r.Images {
+			if r.Images[i].Image == image.Image {
+				r.Images[i] = image
+				return
+			}
+		}
+		r.Images = append(r.Images, image)
+	case EventChartPackaged:
+		chart := ChartReport{Name: event.Name, Version: event.Version, Path: event.File, DurationMS: event.DurationMS}
+		for i := range r.Charts {
+			if r.Charts[i].Path == chart.Path {
+				r.Charts[i] = chart
+				return
+			}
+		}
+		r.Charts = append(r.Charts, chart)
+	}
+}
+
+// Finish sets the versions of the config, the artifacts and the total time of the build
+func (r *BuildReport) Finish(config *ExtensionConfig, artifacts []Artifact) {
+	r.Name = filepath.Base(config.DirPath)
+	r.ExtensionVersion = config.Versions.Extension
+	r.KaapanaBuildVersion = config.KaapanaBuildVersion
+	r.Registry = config.CustomRegistryUrl
+	r.Artifacts = artifacts
+	r.DurationMS = time.Since(r.Started).Milliseconds()
+}
+
+func formatDurationMS(ms int64) string {
+	duration := time.Duration(ms) * time.Millisecond
+	if duration < time.Second {
+		return duration.String()
+	}
+	return duration.Round(100 * time.Millisecond).String()
+}
+
+// imageColumns returns the columns of an image in the report tables, size and layers are
+// unknown for failed builds
+func imageColumns(image ImageReport) []string {
+	duration, size, layers := "-", "-", "-"
+	if image.Status != BuildStatusSkipped {
+		duration = formatDurationMS(image.DurationMS)
+	}
+	if image.Size > 0 {
+		size = formatSize(image.Size)
+		layers = fmt.Sprint(image.Layers)
+	}
+	return []string{image.Image, image.Status, duration, size, layers}
+}
+
+// Print writes the report as tables
+func (r *BuildReport) Print(w io.Writer) error {
+	fmt.Fprintf(w, "Build report of %s %s (Kaapana %s), total time %s\n\n", r.Name, r.ExtensionVersion, r.KaapanaBuildVersion, formatDurationMS(r.DurationMS))
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	if len(r.Images) > 0 {
+		fmt.Fprintln(tw, "IMAGE\tSTATUS\tDURATION\tSIZE\tLAYERS")
+		for _, image := range r.Images {
+			fmt.Fprintln(tw, strings.Join(imageColumns(image), "\t"))
+		}
+		fmt.Fprintln(tw)
+	}
+	if len(r.Charts) > 0 {
+		fmt.Fprintln(tw, "CHART\tVERSION\tPACKAGE")
+		for _, chart := range r.Charts {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", chart.Name, chart.Version, chart.Path)
+		}
+		fmt.Fprintln(tw)
+	}
+	if len(r.Artifacts) > 0 {
+		fmt.Fprintln(tw, "ARTIFACT\tKIND\tSIZE")
+		for _, artifact := range r.Artifacts {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", artifact.Path, artifact.Kind, formatSize(artifact.Size))
+		}
+	}
+	return tw.Flush()
+}
+
+// Markdown returns the report for merge request comments, paths are relative to outputDir
+func (r *BuildReport) Markdown(outputDir string) string {
+	relative := func(path string) string {
+		if rel, err := filepath.Rel(outputDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+		return path
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "### Build report of %s %s\n\n", r.Name, r.ExtensionVersion)
+	fmt.Fprintf(&b, "Kaapana `%s`, registry `%s`, total time %s\n", r.KaapanaBuildVersion, r.Registry, formatDurationMS(r.DurationMS))
+	if len(r.Images) > 0 {
+		b.WriteString("\n| Image | Status | Duration | Size | Layers |\n| --- | --- | --- | --- | --- |\n")
+		for _, image := range r.Images {
+			columns := imageColumns(image)
+			columns[0] = "`" + columns[0] + "`"
+			fmt.Fprintf(&b, "| %s |\n", strings.Join(columns, " | "))
+		}
+	}
+	if len(r.Charts) > 0 {
+		b.WriteString("\n| Chart | Version | Package |\n| --- | --- | --- |\n")
+		for _, chart := range r.Charts {
+			fmt.Fprintf(&b, "| %s | %s | `%s` |\n", chart.Name, chart.Version, relative(chart.Path))
+		}
+	}
+	if len(r.Artifacts) > 0 {
+		b.WriteString("\n| Artifact | Kind | Size | SHA256 |\n| --- | --- | --- | --- |\n")
+		for _, artifact := range r.Artifacts {
+			fmt.Fprintf(&b, "| `%s` | %s | %s | `%s` |\n", relative(artifact.Path), artifact.Kind, formatSize(artifact.Size), artifact.SHA256)
+		}
+	}
+	return b.String()
+}
+
+// Write writes the report as json and markdown into outputDir and returns the paths
+func (r *BuildReport) Write(outputDir string) (string, string, error) {
+	jsonPath := filepath.Join(outputDir, BuildReportFile)
+	content, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(jsonPath, content, 0644); err != nil {
+		return "", "", fmt.Errorf("failed to write %s: %w", jsonPath, err)
+	}
+	markdownPath := filepath.Join(outputDir, BuildReportMarkdownFile)
+	if err := os.WriteFile(markdownPath, []byte(r.Markdown(outputDir)), 0644); err != nil {
+		return "", "", fmt.Errorf("failed to write %s: %w", markdownPath, err)
+	}
+	return jsonPath, markdownPath, nil
+}
@@ -0,0 +1,63 @@
+package util
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildReport(t *testing.T) {
+	outputDir := t.TempDir()
+	report := NewBuildReport()
+	stop := OnEvent(report.Record)
+	Emit(Event{Type: EventBuildFinished, Image: "registry.example.com/otsus-method:0.1.0", Status: BuildStatusFailed})
+	Emit(Event{Type: EventBuildFinished, Image: "registry.example.com/otsus-method:0.1.0", Status: BuildStatusCached, DurationMS: 1234, Bytes: 3 << 20, Layers: 7})
+	Emit(Event{Type: EventBuildFinished, Image: "registry.example.com/base:0.1.0", Status: BuildStatusSkipped})
+	Emit(Event{Type: EventChartPackaged, Name: "otsus-method", Version: "0.1.0", File: filepath.Join(outputDir, "otsus-method-0.1.0.tgz")})
+	stop()
+	Emit(Event{Type: EventBuildFinished, Image: "registry.example.com/ignored:0.1.0", Status: BuildStatusBuilt})
+
+	config := &ExtensionConfig{DirPath: "/extensions/otsus-method", KaapanaBuildVersion: "0.2.2", CustomRegistryUrl: "registry.example.com"}
+	config.Versions.Extension = "0.1.0"
+	report.Finish(config, nil)
+
+	if len(report.Images) != 2 || report.Images[0].Status != BuildStatusCached || report.Images[0].Layers != 7 {
+		t.Fatalf("unexpected images %+v", report.Images)
+	}
+	if len(report.Charts) != 1 || report.Charts[0].Name != "otsus-method" {
+		t.Fatalf("unexpected charts %+v", report.Charts)
+	}
+
+	markdown := report.Markdown(outputDir)
+	for _, expected := range []string{
+		"### Build report of otsus-method 0.1.0",
+		"| `registry.example.com/otsus-method:0.1.0` | cached | 1.2s | 3.0 MiB | 7 |",
+		"| `registry.example.com/base:0.1.0` | skipped | - | - | - |",
+		"| otsus-method | 0.1.0 | `otsus-method-0.1.0.tgz` |",
+	} {
+		if !strings.Contains(markdown, expected) {
+			t.Fatalf("expected %q in markdown:\n%s", expected, markdown)
+		}
+	}
+
+	jsonPath, markdownPath, err := report.Write(outputDir)
+	if err != nil {
+		t.Fatalf("failed to write report: %v", err)
+	}
+	content, err := os.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", jsonPath, err)
+	}
+	var written BuildReport
+	if err := json.Unmarshal(content, &written); err != nil {
+		t.Fatalf("%s is not a valid report: %v", jsonPath, err)
+	}
+	if written.Name != "otsus-method" || len(written.Images) != 2 {
+		t.Fatalf("unexpected written report %+v", written)
+	}
+	if _, err := os.Stat(markdownPath); err != nil {
+		t.Fatalf("markdown report not written: %v", err)
+	}
+}
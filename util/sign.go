@@ -0,0 +1,158 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/fatih/color"
+	"golang.org/x/crypto/openpgp" //nolint
+	"golang.org/x/term"
+	"helm.sh/helm/v3/pkg/provenance"
+	"k8s.io/client-go/util/homedir"
+)
+
+// SignOptions select the OpenPGP key used to sign artifacts, same as 'helm package --sign'
+type SignOptions struct {
+	Key            string
+	Keyring        string
+	PassphraseFile string
+}
+
+var (
+	signersMu sync.Mutex
+	signers   = map[SignOptions]*provenance.Signatory{}
+)
+
+// DefaultSigningKeyring is the legacy GnuPG secret keyring, export it with 'gpg --export-secret-keys > ~/.gnupg/secring.gpg'
+func DefaultSigningKeyring() string {
+	return filepath.Join(homedir.HomeDir(), ".gnupg", "secring.gpg")
+}
+
+// DefaultVerifyKeyring is the legacy GnuPG public keyring, export it with 'gpg --export > ~/.gnupg/pubring.gpg'
+func DefaultVerifyKeyring() string {
+	return filepath.Join(homedir.HomeDir(), ".gnupg", "pubring.gpg")
+}
+
+func passphraseFetcher(passphraseFile string) provenance.PassphraseFetcher {
+	return func(name string) ([]byte, error) {
+		if passphraseFile == "" {
+			fmt.Fprintf(os.Stderr, "Passphrase for key %q > ", name)
+			passphrase, err := term.ReadPassword(int(syscall.Stdin))
+			fmt.Fprintln(os.Stderr)
+			return passphrase, err
+		}
+
+		file := os.Stdin
+		if passphraseFile != "-" {
+			f, err := os.Open(passphraseFile)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			file = f
+		}
+		passphrase, _, err := bufio.NewReader(file).ReadLine()
+		return passphrase, err
+	}
+}
+
+// LoadSigner loads and decrypts the signing key, the key is only decrypted once per run
+func LoadSigner(opts SignOptions) (*provenance.Signatory, error) {
+	signersMu.Lock()
+	defer signersMu.Unlock()
+	if signer, ok := signers[opts]; ok {
+		return signer, nil
+	}
+
+	if opts.Keyring == "" {
+		opts.Keyring = DefaultSigningKeyring()
+	}
+	color.Blue("loading signing key '%s' from %s", opts.Key, opts.Keyring)
+	signer, err := provenance.NewFromKeyring(opts.Keyring, opts.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing key: %w", err)
+	}
+	if opts.Key == "" {
+		// without --key, the only private key in the keyring is used
+		for _, entity := range signer.KeyRing {
+			if entity.PrivateKey == nil {
+				continue
+			}
+			if signer.Entity != nil {
+				return nil, errors.New("keyring " + opts.Keyring + " contains multiple private keys, choose one with --key")
+			}
+			signer.Entity = entity
+		}
+	}
+	if signer.Entity == nil {
+		return nil, errors.New("no private key '" + opts.Key + "' found in keyring " + opts.Keyring)
+	}
+	if err := signer.DecryptKey(passphraseFetcher(opts.PassphraseFile)); err != nil {
+		return nil, fmt.Errorf("failed to decrypt signing key: %w", err)
+	}
+	signers[opts] = signer
+	return signer, nil
+}
+
+// SignFileDetached writes an armored detached signature of path to path.asc
+func SignFileDetached(signer *provenance.Signatory, path string) (string, error) {
+	if signer.Entity == nil || signer.Entity.PrivateKey == nil {
+		return "", errors.New("signing key has no private key")
+	}
+	content, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, signer.Entity, content, nil); err != nil {
+		return "", fmt.Errorf("failed to sign %s: %w", path, err)
+	}
+	sigPath := path + ".asc"
+	if err := os.WriteFile(sigPath, signature.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	color.Blue("signed %s into %s", path, sigPath)
+	return sigPath, nil
+}
+
+// VerifyFileDetached checks the armored detached signature sigPath of path against
+// the keys in keyring and returns the identity of the signer
+func VerifyFileDetached(keyring string, path string, sigPath string) (string, error) {
+	ring, err := os.Open(keyring)
+	if err != nil {
+		return "", err
+	}
+	defer ring.Close()
+	keys, err := openpgp.ReadKeyRing(ring)
+	if err != nil {
+		return "", fmt.Errorf("failed to read keyring %s: %w", keyring, err)
+	}
+
+	content, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+	signature, err := os.Open(sigPath)
+	if err != nil {
+		return "", err
+	}
+	defer signature.Close()
+
+	signer, err := openpgp.CheckArmoredDetachedSignature(keys, content, signature)
+	if err != nil {
+		return "", fmt.Errorf("signature %s of %s is invalid: %w", sigPath, path, err)
+	}
+	for name := range signer.Identities {
+		return name, nil
+	}
+	return fmt.Sprintf("%X", signer.PrimaryKey.Fingerprint), nil
+}
@@ -0,0 +1,141 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/fatih/color"
+)
+
+const (
+	DefaultImageTagTemplate     = "{{.KaapanaBuildVersion}}"
+	DefaultChartVersionTemplate = "{{.KaapanaBuildVersion}}"
+)
+
+// semver 2.0.0, see https://semver.org/#is-there-a-suggested-regular-expression-regex-to-check-a-semver-string
+var semverRegex = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+	`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+// same rule docker and podman apply to the tag part of an image reference
+var imageTagRegex = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+
+// BuildVersions holds the versions resolved from the config for a single run
+type BuildVersions struct {
+	Extension string
+	Image     string
+	Chart     string
+}
+
+type versionTemplateData struct {
+	ExtensionVersion    string
+	KaapanaBuildVersion string
+}
+
+func ValidateSemver(version string) error {
+	if !semverRegex.MatchString(version) {
+		return fmt.Errorf("'%s' is not a valid semantic version", version)
+	}
+	return nil
+}
+
+// GitDescribeVersion derives a semver compatible version from 'git describe' in dirPath.
+// A leading 'v' of the tag is dropped and repositories without tags result in 0.0.0-g<commit>.
+// With dirty, uncommitted changes add a '-dirty' suffix. It is off by default, since the build
+// itself rewrites the config file, Chart.yaml, values.yaml and operator files in dir_path.
+func GitDescribeVersion(ctx context.Context, dirPath string, dirty bool) (string, error) {
+	gitArgs := []string{"-C", dirPath, "describe", "--tags", "--always"}
+	if dirty {
+		gitArgs = append(gitArgs, "--dirty")
+	}
+	out, err := Command(ctx, "git", gitArgs...).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run 'git describe' in %s: %w", dirPath, ContextError(ctx, err))
+	}
+	described := strings.TrimSpace(string(out))
+	if described == "" {
+		return "", errors.New("'git describe' returned an empty version in " + dirPath)
+	}
+
+	version := strings.TrimPrefix(described, "v")
+	if !strings.Contains(version, ".") {
+		// no tag is reachable, only the abbreviated commit was printed
+		version = "0.0.0-g" + version
+	}
+	if err := ValidateSemver(version); err != nil {
+		return "", fmt.Errorf("version '%s' derived from git tag is invalid: %w", described, err)
+	}
+	return version, nil
+}
+
+func renderTemplate(name string, text string, data interface{}) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s '%s': %w", name, text, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render %s '%s': %w", name, text, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// ResolveVersions fills config.Versions from extension_version (or git) and the tag/chart version templates
+func ResolveVersions(ctx context.Context, config *ExtensionConfig) error {
+	extensionVersion := config.ExtensionVersion
+	if extensionVersion != "" {
+		if err := ValidateSemver(extensionVersion); err != nil {
+			return fmt.Errorf("extension_version is invalid: %w", err)
+		}
+	} else {
+		version, err := GitDescribeVersion(ctx, config.DirPath, config.GitDirtyVersion)
+		if err != nil {
+			color.Yellow("could not derive extension version from git, using kaapana_build_version %s: %s", config.KaapanaBuildVersion, err.Error())
+			version = config.KaapanaBuildVersion
+		} else {
+			color.Magenta("derived extension version %s from git", version)
+		}
+		extensionVersion = version
+	}
+
+	imageTagTemplate := config.ImageTagTemplate
+	if imageTagTemplate == "" {
+		imageTagTemplate = DefaultImageTagTemplate
+	}
+	chartVersionTemplate := config.ChartVersionTemplate
+	if chartVersionTemplate == "" {
+		chartVersionTemplate = DefaultChartVersionTemplate
+	}
+
+	data := versionTemplateData{
+		ExtensionVersion:    extensionVersion,
+		KaapanaBuildVersion: config.KaapanaBuildVersion,
+	}
+	imageVersion, err := renderTemplate("image_tag_template", imageTagTemplate, data)
+	if err != nil {
+		return err
+	}
+	if !imageTagRegex.MatchString(imageVersion) {
+		return fmt.Errorf("image tag '%s' rendered from image_tag_template is not a valid image tag", imageVersion)
+	}
+	chartVersion, err := renderTemplate("chart_version_template", chartVersionTemplate, data)
+	if err != nil {
+		return err
+	}
+	if err := ValidateSemver(chartVersion); err != nil {
+		return fmt.Errorf("chart version rendered from chart_version_template is invalid: %w", err)
+	}
+
+	config.Versions = BuildVersions{
+		Extension: extensionVersion,
+		Image:     imageVersion,
+		Chart:     chartVersion,
+	}
+	color.Blue("extension version %s, image tag %s, chart version %s", extensionVersion, imageVersion, chartVersion)
+	return nil
+}
@@ -0,0 +1,88 @@
+package util
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateSemver(t *testing.T) {
+	valid := []string{"0.0.0-latest", "0.2.2", "1.2.3-4-gabc1234-dirty", "1.0.0-rc.1+build.5"}
+	for _, v := range valid {
+		if err := ValidateSemver(v); err != nil {
+			t.Errorf("expected %s to be valid: %v", v, err)
+		}
+	}
+
+	invalid := []string{"", "latest", "1.2", "v1.2.3", "01.2.3", "1.2.3-"}
+	for _, v := range invalid {
+		if err := ValidateSemver(v); err == nil {
+			t.Errorf("expected %s to be invalid", v)
+		}
+	}
+}
+
+func TestResolveVersions(t *testing.T) {
+	config := &ExtensionConfig{
+		DirPath:              t.TempDir(),
+		KaapanaBuildVersion:  "0.2.2",
+		ExtensionVersion:     "1.4.0",
+		ImageTagTemplate:     "{{.ExtensionVersion}}-kaapana{{.KaapanaBuildVersion}}",
+		ChartVersionTemplate: "{{.ExtensionVersion}}-kaapana{{.KaapanaBuildVersion}}",
+	}
+	if err := ResolveVersions(context.Background(), config); err != nil {
+		t.Fatalf("failed to resolve versions: %v", err)
+	}
+	if config.Versions.Image != "1.4.0-kaapana0.2.2" || config.Versions.Chart != "1.4.0-kaapana0.2.2" {
+		t.Fatalf("unexpected versions: %+v", config.Versions)
+	}
+
+	config.ImageTagTemplate = "{{.ExtensionVersion}}+kaapana{{.KaapanaBuildVersion}}"
+	if err := ResolveVersions(context.Background(), config); err == nil {
+		t.Fatalf("expected '+' in the image tag to be rejected")
+	}
+
+	config.ImageTagTemplate = ""
+	config.ExtensionVersion = ""
+	config.ChartVersionTemplate = ""
+	if err := ResolveVersions(context.Background(), config); err != nil {
+		t.Fatalf("failed to resolve default versions: %v", err)
+	}
+	if config.Versions.Extension != "0.2.2" || config.Versions.Image != "0.2.2" || config.Versions.Chart != "0.2.2" {
+		t.Fatalf("expected defaults to fall back to kaapana_build_version, got %+v", config.Versions)
+	}
+}
+
+func TestGitDescribeVersion(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	if err := os.WriteFile(filepath.Join(dir, "values.yaml"), []byte("global: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "values.yaml")
+	git("commit", "-q", "-m", "initial")
+	git("tag", "v1.2.0")
+
+	// files rewritten by the build don't change the version unless git_dirty_version is set
+	if err := os.WriteFile(filepath.Join(dir, "values.yaml"), []byte("global:\n  image_tag: 1.2.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := GitDescribeVersion(context.Background(), dir, false); err != nil || version != "1.2.0" {
+		t.Fatalf("expected 1.2.0, got %q %v", version, err)
+	}
+	if version, err := GitDescribeVersion(context.Background(), dir, true); err != nil || version != "1.2.0-dirty" {
+		t.Fatalf("expected 1.2.0-dirty, got %q %v", version, err)
+	}
+}
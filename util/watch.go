@@ -0,0 +1,207 @@
+package util
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultDebounce is how long the watcher waits for further changes before reporting them
+const DefaultDebounce = 500 * time.Millisecond
+
+// Watcher reports changed files below a directory. Files are only reported if their content
+// changed, builds rewrite files like the operator py files or Chart.yaml with the same content.
+type Watcher struct {
+	root     string
+	ignore   []string
+	debounce time.Duration
+	hashes   map[string][sha256.Size]byte
+	fsw      *fsnotify.Watcher
+}
+
+// NewWatcher watches root recursively, except for the ignored directories, hidden directories
+// and temporary files of editors
+func NewWatcher(root string, ignore []string, debounce time.Duration) (*Watcher, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	ignoreAbs := []string{}
+	for _, dir := range ignore {
+		if dir == "" {
+			continue
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		ignoreAbs = append(ignoreAbs, abs)
+	}
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{root: root, ignore: ignoreAbs, debounce: debounce, hashes: map[string][sha256.Size]byte{}, fsw: fsw}
+	files, err := w.addTree(root)
+	if err != nil {
+		fsw.Close()
+		return nil, err
+	}
+	for _, file := range files {
+		w.contentChanged(file)
+	}
+	return w, nil
+}
+
+func (w *Watcher) Close() error {
+	return w.fsw.Close()
+}
+
+func (w *Watcher) ignored(path string) bool {
+	for _, dir := range w.ignore {
+		if IsInDir(path, dir) {
+			return true
+		}
+	}
+	base := filepath.Base(path)
+	if path != w.root && strings.HasPrefix(base, ".") {
+		return true
+	}
+	// backup and swap files, vim also checks with 4913 whether it can write into a directory
+	return strings.HasSuffix(base, "~") || strings.HasSuffix(base, ".swp") || strings.HasSuffix(base, ".swx") || base == "4913"
+}
+
+// IsInDir returns whether path is dir or below dir, both paths have to be clean
+func IsInDir(path string, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
+
+// addTree watches dir and its subdirectories and returns the files in them
+func (w *Watcher) addTree(dir string) ([]string, error) {
+	files := []string{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// removed while walking
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if w.ignored(path) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return w.fsw.Add(path)
+		}
+		if entry.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+func hashFile(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	file, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// contentChanged updates the hash of the file and returns the files whose content changed, a
+// removed directory returns the files that were in it
+func (w *Watcher) contentChanged(path string) []string {
+	info, err := os.Stat(path)
+	if err != nil {
+		removed := []string{}
+		for file := range w.hashes {
+			if IsInDir(file, path) {
+				delete(w.hashes, file)
+				removed = append(removed, file)
+			}
+		}
+		return removed
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	sum, err := hashFile(path)
+	if err != nil {
+		return nil
+	}
+	if previous, ok := w.hashes[path]; ok && previous == sum {
+		return nil
+	}
+	w.hashes[path] = sum
+	return []string{path}
+}
+
+// Next blocks until files changed and no further change happened for the debounce duration
+func (w *Watcher) Next(ctx context.Context) ([]string, error) {
+	pending := map[string]bool{}
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return nil, errors.New("watcher is closed")
+			}
+			color.Yellow("watching %s: %s", w.root, err.Error())
+		case event, ok := <-w.fsw.Events:
+			if !ok {
+				return nil, errors.New("watcher is closed")
+			}
+			path := filepath.Clean(event.Name)
+			if w.ignored(path) {
+				continue
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(path); err == nil && info.IsDir() {
+					files, err := w.addTree(path)
+					if err != nil {
+						color.Yellow("failed to watch %s: %s", path, err.Error())
+					}
+					for _, file := range files {
+						pending[file] = true
+					}
+				}
+			}
+			pending[path] = true
+			timer = time.After(w.debounce)
+		case <-timer:
+			timer = nil
+			changed := []string{}
+			for path := range pending {
+				changed = append(changed, w.contentChanged(path)...)
+			}
+			pending = map[string]bool{}
+			if len(changed) > 0 {
+				sort.Strings(changed)
+				return changed, nil
+			}
+		}
+	}
+}
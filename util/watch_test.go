@@ -0,0 +1,85 @@
+package util
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func nextChanges(t *testing.T, w *Watcher) []string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	changed, err := w.Next(ctx)
+	if err != nil && err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	return changed
+}
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "files", "otsus_method.py")
+	if err := os.MkdirAll(filepath.Dir(source), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(source, []byte("print('otsu')\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dist := filepath.Join(dir, "dist")
+	if err := os.MkdirAll(dist, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWatcher(dir, []string{dist}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// several writes are reported once
+	for _, content := range []string{"print('otsu')\n#", "print('otsu 2')\n"} {
+		if err := os.WriteFile(source, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if changed := nextChanges(t, w); !reflect.DeepEqual(changed, []string{source}) {
+		t.Fatalf("expected %s to change, got %v", source, changed)
+	}
+
+	// same content, ignored directories and swap files are not reported
+	if err := os.WriteFile(source, []byte("print('otsu 2')\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dist, "images.tar"), []byte("images"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "files", ".otsus_method.py.swp"), []byte("swap"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed := nextChanges(t, w); len(changed) != 0 {
+		t.Fatalf("expected no changes, got %v", changed)
+	}
+
+	// files in new directories are reported
+	added := filepath.Join(dir, "files", "utils", "helpers.py")
+	if err := os.MkdirAll(filepath.Dir(added), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(added, []byte("pass\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed := nextChanges(t, w); !reflect.DeepEqual(changed, []string{added}) {
+		t.Fatalf("expected %s to be added, got %v", added, changed)
+	}
+
+	if err := os.RemoveAll(filepath.Join(dir, "files")); err != nil {
+		t.Fatal(err)
+	}
+	if changed := nextChanges(t, w); !reflect.DeepEqual(changed, []string{source, added}) {
+		t.Fatalf("expected the removed files, got %v", changed)
+	}
+}